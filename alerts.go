@@ -0,0 +1,459 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/luraproject/lura/v2/logging"
+	"github.com/rcrowley/go-metrics"
+)
+
+const (
+	// AlertPending is the state of an alert whose condition holds for less than the for-duration of its rule
+	AlertPending = "pending"
+	// AlertFiring is the state of an alert whose condition holds for at least the for-duration of its rule
+	AlertFiring = "firing"
+	// AlertResolved is the state of a firing alert whose condition does not hold anymore
+	AlertResolved = "resolved"
+
+	// defaultWebhookTimeout is the max time of every webhook request when the config does not define it
+	defaultWebhookTimeout = 5 * time.Second
+	// defaultWebhookRetries is the number of retries of a failed notification when the config does not
+	// define it
+	defaultWebhookRetries = 3
+	// defaultWebhookBackoff is the wait before the first retry, doubled on every retry, when the config
+	// does not define it
+	defaultWebhookBackoff = time.Second
+	// webhookQueueSize is the number of notifications waiting to be sent before dropping the new ones
+	webhookQueueSize = 64
+)
+
+var alertComparators = map[string]func(v, threshold float64) bool{
+	">":  func(v, t float64) bool { return v > t },
+	">=": func(v, t float64) bool { return v >= t },
+	"<":  func(v, t float64) bool { return v < t },
+	"<=": func(v, t float64) bool { return v <= t },
+	"==": func(v, t float64) bool { return v == t },
+	"!=": func(v, t float64) bool { return v != t },
+}
+
+// AlertRule defines the condition raising an alert for every metric of the snapshot matching it
+type AlertRule struct {
+	Name string
+	// Metric is a glob pattern selecting the metrics of the snapshot by name. The counters are evaluated
+	// with their increment since the previous collection and the histogram fields are selected with
+	// the suffixes .max, .min, .mean, .stddev and .p10 to .p99
+	Metric string
+	// Op is the comparison of the value with the threshold: >, >=, <, <=, == or !=
+	Op        string
+	Threshold float64
+	// For is the time the condition must hold before the alert fires
+	For time.Duration
+}
+
+// WebhookConfig defines the endpoint receiving the alert notifications
+type WebhookConfig struct {
+	URL     string
+	Headers map[string]string
+	Timeout time.Duration
+	// Retries is the number of retries of a failed notification
+	Retries int
+	// Backoff is the wait before the first retry. It is doubled on every retry
+	Backoff time.Duration
+}
+
+// AlertsConfig is the config of the alert rules and their notifications
+type AlertsConfig struct {
+	Rules   []AlertRule
+	Webhook WebhookConfig
+}
+
+func parseAlertsConfig(data map[string]interface{}) *AlertsConfig {
+	tmp, ok := data["alerts"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	webhook, ok := tmp["webhook"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	cfg := &AlertsConfig{
+		Webhook: WebhookConfig{
+			Headers: map[string]string{},
+			Timeout: defaultWebhookTimeout,
+			Retries: defaultWebhookRetries,
+			Backoff: defaultWebhookBackoff,
+		},
+	}
+	cfg.Webhook.URL, _ = webhook["url"].(string)
+	if cfg.Webhook.URL == "" {
+		return nil
+	}
+	if headers, ok := webhook["headers"].(map[string]interface{}); ok {
+		for k, v := range headers {
+			if s, ok := v.(string); ok {
+				cfg.Webhook.Headers[k] = s
+			}
+		}
+	}
+	if v, ok := webhook["timeout"].(string); ok {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			cfg.Webhook.Timeout = d
+		}
+	}
+	if v, ok := webhook["retries"].(float64); ok && v >= 0 {
+		cfg.Webhook.Retries = int(v)
+	}
+	if v, ok := webhook["backoff"].(string); ok {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			cfg.Webhook.Backoff = d
+		}
+	}
+
+	rules, _ := tmp["rules"].([]interface{})
+	for _, v := range rules {
+		r, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		rule := AlertRule{Op: ">"}
+		rule.Name, _ = r["name"].(string)
+		rule.Metric, _ = r["metric"].(string)
+		if op, ok := r["op"].(string); ok {
+			rule.Op = op
+		}
+		threshold, ok := r["threshold"].(float64)
+		if !ok || rule.Name == "" || rule.Metric == "" || alertComparators[rule.Op] == nil {
+			continue
+		}
+		rule.Threshold = threshold
+		if v, ok := r["for"].(string); ok {
+			if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+				rule.For = d
+			}
+		}
+		cfg.Rules = append(cfg.Rules, rule)
+	}
+	if len(cfg.Rules) == 0 {
+		return nil
+	}
+	return cfg
+}
+
+// Alert is the state of a rule for one of the metrics matching it
+type Alert struct {
+	Rule      string    `json:"rule"`
+	Metric    string    `json:"metric"`
+	Status    string    `json:"status"`
+	Value     float64   `json:"value"`
+	Op        string    `json:"op"`
+	Threshold float64   `json:"threshold"`
+	StartsAt  time.Time `json:"starts_at"`
+	EndsAt    time.Time `json:"ends_at,omitzero"`
+}
+
+// AlertNotification is the body of the webhook requests
+type AlertNotification struct {
+	Alerts []Alert `json:"alerts"`
+}
+
+type alertRule struct {
+	AlertRule
+	match   *regexp.Regexp
+	compare func(v, threshold float64) bool
+	pending metrics.Gauge
+	firing  metrics.Gauge
+}
+
+type alertKey struct {
+	rule   string
+	metric string
+}
+
+// Alerter evaluates the alert rules on every snapshot and notifies the webhook when an alert fires or
+// gets resolved. Every alert is notified once per state, so the notifications are deduplicated. It is
+// safe for concurrent use
+type Alerter struct {
+	rules    []alertRule
+	notifier *webhookNotifier
+
+	mu       sync.Mutex
+	active   map[alertKey]*Alert
+	counters map[string]int64
+}
+
+// NewAlerter creates an alerter and starts its webhook notifier. The gauges alerts.<rule>.pending and
+// alerts.<rule>.firing and the counters of the notifier are registered in the registry
+func NewAlerter(cfg *AlertsConfig, r metrics.Registry, l logging.Logger) (*Alerter, error) {
+	a := &Alerter{active: map[alertKey]*Alert{}}
+	for _, rule := range cfg.Rules {
+		compare, ok := alertComparators[rule.Op]
+		if !ok {
+			return nil, fmt.Errorf("unknown comparison %q in the alert rule %q", rule.Op, rule.Name)
+		}
+		match, err := compileGlob(rule.Metric)
+		if err != nil {
+			return nil, err
+		}
+		a.rules = append(a.rules, alertRule{
+			AlertRule: rule,
+			match:     match,
+			compare:   compare,
+			pending:   metrics.GetOrRegisterGauge("alerts."+rule.Name+".pending", r),
+			firing:    metrics.GetOrRegisterGauge("alerts."+rule.Name+".firing", r),
+		})
+	}
+	a.notifier = newWebhookNotifier(cfg.Webhook, r, l)
+	return a, nil
+}
+
+// Active returns the pending and firing alerts sorted by rule and metric
+func (a *Alerter) Active() []Alert {
+	a.mu.Lock()
+	res := make([]Alert, 0, len(a.active))
+	for _, alert := range a.active {
+		res = append(res, *alert)
+	}
+	a.mu.Unlock()
+	sortAlerts(res)
+	return res
+}
+
+// Evaluate updates the state of the alerts with the values of the snapshot, using its time as the
+// current time, and notifies the alerts firing or resolved. The counters are skipped on the first
+// evaluation, as there is no previous value to compute their increment. It does nothing on a nil
+// alerter
+func (a *Alerter) Evaluate(s Stats) {
+	if a == nil {
+		return
+	}
+	now := time.Unix(0, s.Time)
+
+	a.mu.Lock()
+	seen := map[alertKey]bool{}
+	changes := []Alert{}
+	for i := range a.rules {
+		rule := &a.rules[i]
+		var pending, firing int64
+		a.eachValue(s, rule.match, func(name string, v float64) {
+			if !rule.compare(v, rule.Threshold) {
+				return
+			}
+			key := alertKey{rule.Name, name}
+			seen[key] = true
+			alert, ok := a.active[key]
+			if !ok {
+				alert = &Alert{Rule: rule.Name, Metric: name, Status: AlertPending, Op: rule.Op, Threshold: rule.Threshold, StartsAt: now}
+				a.active[key] = alert
+			}
+			alert.Value = v
+			if alert.Status == AlertPending && now.Sub(alert.StartsAt) >= rule.For {
+				alert.Status = AlertFiring
+				changes = append(changes, *alert)
+			}
+			if alert.Status == AlertFiring {
+				firing++
+			} else {
+				pending++
+			}
+		})
+		rule.pending.Update(pending)
+		rule.firing.Update(firing)
+	}
+	for key, alert := range a.active {
+		if seen[key] {
+			continue
+		}
+		if alert.Status == AlertFiring {
+			alert.Status = AlertResolved
+			alert.EndsAt = now
+			changes = append(changes, *alert)
+		}
+		delete(a.active, key)
+	}
+	a.counters = s.Counters
+	a.mu.Unlock()
+
+	if len(changes) == 0 {
+		return
+	}
+	sortAlerts(changes)
+	a.notifier.send(AlertNotification{Alerts: changes})
+}
+
+// eachValue calls fn with the values of the snapshot whose names match the pattern
+func (a *Alerter) eachValue(s Stats, match *regexp.Regexp, fn func(name string, v float64)) {
+	if a.counters != nil {
+		for k, v := range s.Counters {
+			if match.MatchString(k) {
+				fn(k, float64(v-a.counters[k]))
+			}
+		}
+	}
+	for k, v := range s.Gauges {
+		if match.MatchString(k) {
+			fn(k, float64(v))
+		}
+	}
+	for k, v := range s.FloatGauges {
+		if match.MatchString(k) {
+			fn(k, v)
+		}
+	}
+	for k, h := range s.Histograms {
+		fields := map[string]float64{"max": float64(h.Max), "min": float64(h.Min), "mean": h.Mean, "stddev": h.Stddev}
+		for i, p := range percentiles {
+			if i < len(h.Percentiles) {
+				fields["p"+formatFloat(p*100)] = h.Percentiles[i]
+			}
+		}
+		for field, v := range fields {
+			if name := k + "." + field; match.MatchString(name) {
+				fn(name, v)
+			}
+		}
+	}
+}
+
+// Close stops the notifier, dropping the pending notifications and retries. It does nothing on a nil
+// alerter
+func (a *Alerter) Close() {
+	if a == nil {
+		return
+	}
+	a.notifier.close()
+}
+
+func sortAlerts(alerts []Alert) {
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].Rule != alerts[j].Rule {
+			return alerts[i].Rule < alerts[j].Rule
+		}
+		return alerts[i].Metric < alerts[j].Metric
+	})
+}
+
+// webhookNotifier posts the notifications to the webhook from its own goroutine, retrying the failed
+// ones with an exponential backoff
+type webhookNotifier struct {
+	cfg    WebhookConfig
+	client *http.Client
+	queue  chan AlertNotification
+	done   chan struct{}
+	// ctx is canceled on close, interrupting the requests and the backoffs
+	ctx     context.Context
+	cancel  context.CancelFunc
+	sent    metrics.Counter
+	retries metrics.Counter
+	errors  metrics.Counter
+	dropped metrics.Counter
+	logger  logging.Logger
+}
+
+func newWebhookNotifier(cfg WebhookConfig, r metrics.Registry, l logging.Logger) *webhookNotifier {
+	ctx, cancel := context.WithCancel(context.Background())
+	n := &webhookNotifier{
+		cfg:     cfg,
+		ctx:     ctx,
+		cancel:  cancel,
+		client:  &http.Client{},
+		queue:   make(chan AlertNotification, webhookQueueSize),
+		done:    make(chan struct{}),
+		sent:    metrics.GetOrRegisterCounter("alerts.notifications.sent", r),
+		retries: metrics.GetOrRegisterCounter("alerts.notifications.retries", r),
+		errors:  metrics.GetOrRegisterCounter("alerts.notifications.errors", r),
+		dropped: metrics.GetOrRegisterCounter("alerts.notifications.dropped", r),
+		logger:  l,
+	}
+	go n.run()
+	return n
+}
+
+// send queues the notification without waiting for it. It is dropped if the queue is full
+func (n *webhookNotifier) send(notification AlertNotification) {
+	select {
+	case n.queue <- notification:
+	default:
+		n.dropped.Inc(1)
+	}
+}
+
+func (n *webhookNotifier) close() {
+	n.cancel()
+	close(n.queue)
+	<-n.done
+}
+
+func (n *webhookNotifier) run() {
+	defer close(n.done)
+	for notification := range n.queue {
+		if n.ctx.Err() != nil {
+			n.dropped.Inc(1)
+			continue
+		}
+		body, err := json.Marshal(notification)
+		if err != nil {
+			n.errors.Inc(1)
+			continue
+		}
+		n.deliver(body)
+	}
+}
+
+// deliver posts the body until it succeeds, the retries are exhausted or the notifier is closed
+func (n *webhookNotifier) deliver(body []byte) {
+	backoff := n.cfg.Backoff
+	for attempt := 0; ; attempt++ {
+		err := n.post(body)
+		switch {
+		case err == nil:
+			n.sent.Inc(1)
+			return
+		case n.ctx.Err() != nil:
+			n.dropped.Inc(1)
+			return
+		case attempt == n.cfg.Retries:
+			n.errors.Inc(1)
+			n.logger.Error(fmt.Sprintf("[SERVICE: Stats] Unable to send the alert notification: %s", err))
+			return
+		}
+		n.retries.Inc(1)
+		select {
+		case <-time.After(backoff):
+		case <-n.ctx.Done():
+			n.dropped.Inc(1)
+			return
+		}
+		backoff *= 2
+	}
+}
+
+func (n *webhookNotifier) post(body []byte) error {
+	ctx, cancel := context.WithTimeout(n.ctx, n.cfg.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range n.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.New("unexpected status code: " + resp.Status)
+	}
+	return nil
+}
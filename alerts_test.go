@@ -0,0 +1,256 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/luraproject/lura/v2/logging"
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestParseAlertsConfig(t *testing.T) {
+	cfg := parseAlertsConfig(map[string]interface{}{
+		"alerts": map[string]interface{}{
+			"webhook": map[string]interface{}{
+				"url":     "http://example.com/hook",
+				"headers": map[string]interface{}{"Authorization": "Bearer secret"},
+				"retries": 0.0,
+				"backoff": "100ms",
+			},
+			"rules": []interface{}{
+				map[string]interface{}{"name": "errors", "metric": "krakend.router.*.status.5??.count", "threshold": 10.0, "for": "2m"},
+				map[string]interface{}{"name": "apdex", "metric": "krakend.router.*.apdex", "op": "<", "threshold": 0.7},
+				map[string]interface{}{"name": "bad-op", "metric": "*", "op": "=~", "threshold": 1.0},
+				map[string]interface{}{"name": "no-threshold", "metric": "*"},
+			},
+		},
+	})
+	if cfg == nil {
+		t.Fatal("nil config")
+	}
+	if cfg.Webhook.URL != "http://example.com/hook" || cfg.Webhook.Headers["Authorization"] != "Bearer secret" ||
+		cfg.Webhook.Retries != 0 || cfg.Webhook.Backoff != 100*time.Millisecond || cfg.Webhook.Timeout != defaultWebhookTimeout {
+		t.Errorf("unexpected webhook config: %+v", cfg.Webhook)
+	}
+	want := []AlertRule{
+		{Name: "errors", Metric: "krakend.router.*.status.5??.count", Op: ">", Threshold: 10, For: 2 * time.Minute},
+		{Name: "apdex", Metric: "krakend.router.*.apdex", Op: "<", Threshold: 0.7},
+	}
+	if len(cfg.Rules) != len(want) {
+		t.Fatalf("unexpected rules: %+v", cfg.Rules)
+	}
+	for i := range want {
+		if cfg.Rules[i] != want[i] {
+			t.Errorf("unexpected rule #%d: %+v", i, cfg.Rules[i])
+		}
+	}
+
+	if cfg := parseAlertsConfig(map[string]interface{}{"alerts": map[string]interface{}{"rules": []interface{}{}}}); cfg != nil {
+		t.Errorf("a config without webhook should be ignored: %+v", cfg)
+	}
+}
+
+func TestAlerter(t *testing.T) {
+	var requests atomic.Int64
+	notifications := make(chan AlertNotification, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the first notification is retried
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected headers: %v", r.Header)
+		}
+		var n AlertNotification
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			t.Error(err)
+		}
+		notifications <- n
+	}))
+	defer ts.Close()
+
+	registry := metrics.NewRegistry()
+	l, _ := logging.NewLogger("DEBUG", new(bytes.Buffer), "")
+	a, err := NewAlerter(&AlertsConfig{
+		Rules: []AlertRule{
+			{Name: "errors", Metric: "krakend.router.response.*.status.5??.count", Op: ">", Threshold: 5, For: 2 * time.Minute},
+			{Name: "latency", Metric: "krakend.router.response.*.time.p99", Op: ">=", Threshold: 1e9},
+		},
+		Webhook: WebhookConfig{
+			URL:     ts.URL,
+			Headers: map[string]string{"Authorization": "Bearer secret"},
+			Timeout: time.Second,
+			Retries: 2,
+			Backoff: time.Millisecond,
+		},
+	}, registry, l)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	snapshot := func(minute int, errors int64, p99 float64) Stats {
+		s := NewStats()
+		s.Time = start.Add(time.Duration(minute) * time.Minute).UnixNano()
+		s.Counters["krakend.router.response./foo.status.500.count"] = errors
+		s.Counters["krakend.router.response./foo.status.200.count"] = 1000 * int64(minute)
+		if p99 > 0 {
+			s.Histograms["krakend.router.response./foo.time"] = HistogramData{Percentiles: []float64{0, 0, 0, 0, 0, 0, p99}}
+		}
+		return s
+	}
+
+	// the counters are not evaluated without a previous snapshot
+	a.Evaluate(snapshot(0, 100, 2e9))
+	n := receiveNotification(t, notifications)
+	if len(n.Alerts) != 1 || n.Alerts[0].Rule != "latency" || n.Alerts[0].Status != AlertFiring ||
+		n.Alerts[0].Metric != "krakend.router.response./foo.time.p99" || n.Alerts[0].Value != 2e9 || !n.Alerts[0].StartsAt.Equal(start) {
+		t.Errorf("unexpected notification: %+v", n)
+	}
+
+	a.Evaluate(snapshot(1, 110, 2e9))
+	a.Evaluate(snapshot(2, 120, 2e9))
+	active := a.Active()
+	if len(active) != 2 || active[0].Rule != "errors" || active[0].Status != AlertPending || active[1].Status != AlertFiring {
+		t.Errorf("unexpected active alerts: %+v", active)
+	}
+	if v := registry.Get("alerts.errors.pending").(metrics.Gauge).Value(); v != 1 {
+		t.Errorf("unexpected pending alerts: %d", v)
+	}
+
+	a.Evaluate(snapshot(3, 130, 2e9))
+	n = receiveNotification(t, notifications)
+	if len(n.Alerts) != 1 || n.Alerts[0].Rule != "errors" || n.Alerts[0].Status != AlertFiring || n.Alerts[0].Value != 10 {
+		t.Errorf("unexpected notification: %+v", n)
+	}
+	if v := registry.Get("alerts.errors.firing").(metrics.Gauge).Value(); v != 1 {
+		t.Errorf("unexpected firing alerts: %d", v)
+	}
+
+	a.Evaluate(snapshot(4, 130, 0))
+	n = receiveNotification(t, notifications)
+	end := start.Add(4 * time.Minute)
+	if len(n.Alerts) != 2 || n.Alerts[0].Status != AlertResolved || n.Alerts[1].Status != AlertResolved ||
+		!n.Alerts[0].EndsAt.Equal(end) || !n.Alerts[1].EndsAt.Equal(end) {
+		t.Errorf("unexpected notification: %+v", n)
+	}
+	if active := a.Active(); len(active) != 0 {
+		t.Errorf("unexpected active alerts: %+v", active)
+	}
+
+	waitCount(t, registry.Get("alerts.notifications.sent").(metrics.Counter), 3)
+	a.Close()
+	select {
+	case n := <-notifications:
+		t.Errorf("unexpected notification: %+v", n)
+	default:
+	}
+	for name, want := range map[string]int64{"sent": 3, "retries": 1, "errors": 0, "dropped": 0} {
+		if v := registry.Get("alerts.notifications." + name).(metrics.Counter).Count(); v != want {
+			t.Errorf("unexpected %s notifications: %d", name, v)
+		}
+	}
+
+	var nilAlerter *Alerter
+	nilAlerter.Evaluate(snapshot(5, 0, 0))
+	nilAlerter.Close()
+}
+
+func TestAlerter_retriesExhausted(t *testing.T) {
+	var requests atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	registry := metrics.NewRegistry()
+	buf := new(bytes.Buffer)
+	l, _ := logging.NewLogger("DEBUG", buf, "")
+	a, err := NewAlerter(&AlertsConfig{
+		Rules:   []AlertRule{{Name: "gauge", Metric: "a.gauge", Op: "==", Threshold: 1}},
+		Webhook: WebhookConfig{URL: ts.URL, Timeout: time.Second, Retries: 2, Backoff: time.Millisecond},
+	}, registry, l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewStats()
+	s.Gauges["a.gauge"] = 1
+	a.Evaluate(s)
+	waitCount(t, registry.Get("alerts.notifications.errors").(metrics.Counter), 1)
+	a.Close()
+
+	if v := requests.Load(); v != 3 {
+		t.Errorf("unexpected number of requests: %d", v)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte("Unable to send the alert notification")) {
+		t.Errorf("the failure has not been logged: %s", buf.String())
+	}
+}
+
+func TestAlerter_close(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	registry := metrics.NewRegistry()
+	l, _ := logging.NewLogger("DEBUG", new(bytes.Buffer), "")
+	a, err := NewAlerter(&AlertsConfig{
+		Rules:   []AlertRule{{Name: "gauge", Metric: "a.gauge", Op: "==", Threshold: 1}},
+		Webhook: WebhookConfig{URL: ts.URL, Timeout: time.Second, Retries: 3, Backoff: time.Hour},
+	}, registry, l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewStats()
+	s.Gauges["a.gauge"] = 1
+	a.Evaluate(s)
+	waitCount(t, registry.Get("alerts.notifications.retries").(metrics.Counter), 1)
+	// the resolution waits in the queue while the first notification is backing off
+	s.Gauges["a.gauge"] = 0
+	a.Evaluate(s)
+
+	closed := make(chan struct{})
+	go func() {
+		a.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("the alerter has not been closed")
+	}
+	for name, want := range map[string]int64{"sent": 0, "retries": 1, "errors": 0, "dropped": 2} {
+		if v := registry.Get("alerts.notifications." + name).(metrics.Counter).Count(); v != want {
+			t.Errorf("unexpected %s notifications: %d", name, v)
+		}
+	}
+}
+
+// waitCount waits for the counter to reach the value, since the notifier runs in its own goroutine
+func waitCount(t *testing.T, c metrics.Counter, want int64) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); c.Count() != want; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected count: %d", c.Count())
+		}
+	}
+}
+
+func receiveNotification(t *testing.T, notifications <-chan AlertNotification) AlertNotification {
+	t.Helper()
+	select {
+	case n := <-notifications:
+		return n
+	case <-time.After(5 * time.Second):
+		t.Fatal("notification not received")
+	}
+	return AlertNotification{}
+}
@@ -0,0 +1,306 @@
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+const (
+	// AnomalyRate is the signal of the requests per second of an endpoint
+	AnomalyRate = "rate"
+	// AnomalyErrorRatio is the signal of the share of responses with a status code >= 500 of an endpoint
+	AnomalyErrorRatio = "error_ratio"
+	// AnomalyP95 is the signal of the 95th percentile of the response time of an endpoint (ns)
+	AnomalyP95 = "p95"
+
+	// defaultAnomalySigma is the deviation flagged as anomalous when the config does not define it
+	defaultAnomalySigma = 3
+	// defaultAnomalyAlpha is the smoothing factor of the baselines when the config does not define it
+	defaultAnomalyAlpha = 0.1
+	// defaultAnomalyWarmup is the number of observations of a baseline before flagging its deviations
+	// when the config does not define it
+	defaultAnomalyWarmup = 10
+	// defaultSeasonSlots is the number of baselines of a season when the config does not define it
+	defaultSeasonSlots = 24
+)
+
+// p95Index is the position of the 95th percentile in the percentiles of the histograms
+var p95Index = slices.Index(percentiles, 0.95)
+
+// AnomaliesConfig is the config of the anomaly detection of the endpoints
+type AnomaliesConfig struct {
+	// Sigma is the number of standard deviations from the baseline flagged as anomalous
+	Sigma float64
+	// Alpha is the weight of every new observation in the baseline (0 < alpha <= 1)
+	Alpha float64
+	// Warmup is the number of observations of a baseline before flagging its deviations
+	Warmup int
+	// Season enables the seasonal baselines: the season is split into SeasonSlots slots and every
+	// slot gets its own baseline, so the daily or weekly patterns are not flagged
+	Season      time.Duration
+	SeasonSlots int
+}
+
+func parseAnomaliesConfig(data map[string]interface{}) *AnomaliesConfig {
+	tmp, ok := data["anomalies"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	cfg := &AnomaliesConfig{
+		Sigma:       defaultAnomalySigma,
+		Alpha:       defaultAnomalyAlpha,
+		Warmup:      defaultAnomalyWarmup,
+		SeasonSlots: defaultSeasonSlots,
+	}
+	if v, ok := tmp["sigma"].(float64); ok && v > 0 {
+		cfg.Sigma = v
+	}
+	if v, ok := tmp["alpha"].(float64); ok && v > 0 && v <= 1 {
+		cfg.Alpha = v
+	}
+	if v, ok := tmp["warmup"].(float64); ok && v >= 0 {
+		cfg.Warmup = int(v)
+	}
+	if v, ok := tmp["season"].(string); ok {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			cfg.Season = d
+		}
+	}
+	if v, ok := tmp["season_slots"].(float64); ok && v > 0 {
+		cfg.SeasonSlots = int(v)
+	}
+	return cfg
+}
+
+// AnomalyEvent is an observation of a signal deviating from its baseline more than the configured sigma
+type AnomalyEvent struct {
+	Endpoint string  `json:"endpoint"`
+	Signal   string  `json:"signal"`
+	Time     int64   `json:"time"`
+	Value    float64 `json:"value"`
+	Baseline float64 `json:"baseline"`
+	Stddev   float64 `json:"stddev"`
+	// Score is the deviation from the baseline in standard deviations
+	Score float64 `json:"score"`
+}
+
+// ewma is an exponentially weighted moving average of the mean and the variance of a signal
+type ewma struct {
+	mean     float64
+	variance float64
+	n        int
+}
+
+func (e *ewma) update(x, alpha float64) {
+	if e.n == 0 {
+		e.mean = x
+		e.n = 1
+		return
+	}
+	diff := x - e.mean
+	incr := alpha * diff
+	e.mean += incr
+	e.variance = (1 - alpha) * (e.variance + diff*incr)
+	e.n++
+}
+
+// stddev returns the standard deviation of the baseline with a floor, so the flat series do not turn
+// any small change into an anomaly
+func (e *ewma) stddev() float64 {
+	return math.Max(math.Sqrt(e.variance), 0.01*math.Abs(e.mean)+0.001)
+}
+
+type endpointBaselines struct {
+	total   int64
+	errors  int64
+	signals map[string][]ewma
+	// published is the time of the last snapshot publishing the gauges of every signal
+	published map[string]int64
+}
+
+// AnomalyDetector keeps a baseline of the request rate, the error ratio and the p95 latency of every
+// endpoint from the stream of snapshots and flags the observations deviating from them. It is safe
+// for concurrent use
+type AnomalyDetector struct {
+	cfg      AnomaliesConfig
+	prefix   string
+	registry metrics.Registry
+
+	mu        sync.Mutex
+	last      int64
+	endpoints map[string]*endpointBaselines
+}
+
+// NewAnomalyDetector creates an anomaly detector. The prefix is the one of the metric names of the
+// snapshots (ex: "krakend."). The gauges anomalies.<endpoint>.<signal>.score and
+// anomalies.<endpoint>.<signal>.anomalous are registered in the registry. It fails if the season can
+// not be split in slots of the same length
+func NewAnomalyDetector(cfg *AnomaliesConfig, prefix string, r metrics.Registry) (*AnomalyDetector, error) {
+	if cfg.Season > 0 && (cfg.SeasonSlots <= 0 || cfg.Season < time.Duration(cfg.SeasonSlots) ||
+		cfg.Season%time.Duration(cfg.SeasonSlots) != 0) {
+		return nil, fmt.Errorf("the season %s can not be split in %d slots of the same length", cfg.Season, cfg.SeasonSlots)
+	}
+	return &AnomalyDetector{cfg: *cfg, prefix: prefix, registry: r, endpoints: map[string]*endpointBaselines{}}, nil
+}
+
+// Observe compares the signals of the endpoints in the snapshot with their baselines, updates the
+// baselines and returns the anomalies sorted by endpoint and signal. The scores and the flags are
+// published as gauges and added to the snapshot, so the snapshot must not be shared yet. It does
+// nothing on a nil detector
+func (d *AnomalyDetector) Observe(s *Stats) []AnomalyEvent {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	interval := time.Duration(s.Time - d.last)
+	first := d.last == 0
+	d.last = s.Time
+	slot := d.slot(s.Time)
+
+	res := []AnomalyEvent{}
+	for name, counts := range d.countResponses(s) {
+		e, ok := d.endpoints[name]
+		if !ok {
+			e = &endpointBaselines{signals: map[string][]ewma{}, published: map[string]int64{}}
+			d.endpoints[name] = e
+		}
+		values := map[string]float64{}
+		if !first && interval > 0 {
+			total, errors := counts[0]-e.total, counts[1]-e.errors
+			if total >= 0 {
+				values[AnomalyRate] = float64(total) / interval.Seconds()
+			}
+			if total > 0 && errors >= 0 {
+				values[AnomalyErrorRatio] = float64(errors) / float64(total)
+			}
+		}
+		e.total, e.errors = counts[0], counts[1]
+		if h, ok := s.Histograms[d.prefix+"router.response."+name+".time"]; ok && h.Max > 0 && p95Index >= 0 && len(h.Percentiles) > p95Index {
+			values[AnomalyP95] = h.Percentiles[p95Index]
+		}
+
+		for signal, v := range values {
+			baselines, ok := e.signals[signal]
+			if !ok {
+				baselines = make([]ewma, d.slots())
+				e.signals[signal] = baselines
+			}
+			b := &baselines[slot]
+			if b.n >= d.cfg.Warmup && b.n > 0 {
+				stddev := b.stddev()
+				score := (v - b.mean) / stddev
+				anomalous := math.Abs(score) > d.cfg.Sigma
+				d.publish(s, name, signal, score, anomalous)
+				e.published[signal] = s.Time
+				if anomalous {
+					res = append(res, AnomalyEvent{
+						Endpoint: name,
+						Signal:   signal,
+						Time:     s.Time,
+						Value:    v,
+						Baseline: b.mean,
+						Stddev:   stddev,
+						Score:    score,
+					})
+				}
+			}
+			b.update(v, d.cfg.Alpha)
+		}
+	}
+
+	// the signals not observed in the snapshot (ex: the error ratio of an endpoint without traffic) are
+	// not anomalous anymore
+	for name, e := range d.endpoints {
+		for signal, t := range e.published {
+			if t != s.Time {
+				d.publish(s, name, signal, 0, false)
+				delete(e.published, signal)
+			}
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Endpoint != res[j].Endpoint {
+			return res[i].Endpoint < res[j].Endpoint
+		}
+		return res[i].Signal < res[j].Signal
+	})
+	s.Anomalies = append(s.Anomalies, res...)
+	return res
+}
+
+// countResponses returns the total and the failed (status code >= 500) responses of every endpoint
+// in the snapshot
+func (d *AnomalyDetector) countResponses(s *Stats) map[string][2]int64 {
+	prefix := d.prefix + "router.response."
+	res := map[string][2]int64{}
+	for k, v := range s.Counters {
+		if !strings.HasPrefix(k, prefix) || !strings.HasSuffix(k, ".count") {
+			continue
+		}
+		name, code, ok := cutLast(strings.TrimSuffix(strings.TrimPrefix(k, prefix), ".count"), ".status.")
+		if !ok {
+			continue
+		}
+		status, err := strconv.Atoi(code)
+		if err != nil {
+			continue
+		}
+		counts := res[name]
+		counts[0] += v
+		if status >= 500 {
+			counts[1] += v
+		}
+		res[name] = counts
+	}
+	return res
+}
+
+func (d *AnomalyDetector) publish(s *Stats, endpoint, signal string, score float64, anomalous bool) {
+	name := "anomalies." + endpoint + "." + signal
+	flag := int64(0)
+	if anomalous {
+		flag = 1
+	}
+	metrics.GetOrRegisterGaugeFloat64(name+".score", d.registry).Update(score)
+	metrics.GetOrRegisterGauge(name+".anomalous", d.registry).Update(flag)
+	s.FloatGauges[d.prefix+"service."+name+".score"] = score
+	s.Gauges[d.prefix+"service."+name+".anomalous"] = flag
+}
+
+func (d *AnomalyDetector) slots() int {
+	if d.cfg.Season <= 0 {
+		return 1
+	}
+	return d.cfg.SeasonSlots
+}
+
+// slot returns the position of the time in the season
+func (d *AnomalyDetector) slot(t int64) int {
+	if d.cfg.Season <= 0 {
+		return 0
+	}
+	offset := t % int64(d.cfg.Season)
+	if offset < 0 {
+		offset += int64(d.cfg.Season)
+	}
+	return int(offset / (int64(d.cfg.Season) / int64(d.cfg.SeasonSlots)) % int64(d.cfg.SeasonSlots))
+}
+
+// cutLast slices s around the last instance of sep
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
@@ -0,0 +1,237 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"math/rand/v2"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/logging"
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestParseAnomaliesConfig(t *testing.T) {
+	cfg := parseAnomaliesConfig(map[string]interface{}{
+		"anomalies": map[string]interface{}{"sigma": 4.0, "alpha": 0.2, "season": "168h", "season_slots": 168.0},
+	})
+	want := AnomaliesConfig{Sigma: 4, Alpha: 0.2, Warmup: defaultAnomalyWarmup, Season: 168 * time.Hour, SeasonSlots: 168}
+	if cfg == nil || *cfg != want {
+		t.Errorf("unexpected config: %+v", cfg)
+	}
+	cfg = parseAnomaliesConfig(map[string]interface{}{"anomalies": map[string]interface{}{"alpha": 2.0}})
+	want = AnomaliesConfig{Sigma: defaultAnomalySigma, Alpha: defaultAnomalyAlpha, Warmup: defaultAnomalyWarmup, SeasonSlots: defaultSeasonSlots}
+	if cfg == nil || *cfg != want {
+		t.Errorf("unexpected default config: %+v", cfg)
+	}
+}
+
+func TestNewAnomalyDetector_invalidSeason(t *testing.T) {
+	for _, tmp := range []map[string]interface{}{
+		{"season": "10ns", "season_slots": 24.0},
+		{"season": "25h", "season_slots": 7.0},
+	} {
+		cfg := parseAnomaliesConfig(map[string]interface{}{"anomalies": tmp})
+		if d, err := NewAnomalyDetector(cfg, "krakend.", metrics.NewRegistry()); err == nil || d != nil {
+			t.Errorf("the season %v has been accepted", tmp)
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	l, _ := logging.NewLogger("DEBUG", buf, "")
+	m := New(context.Background(), config.ExtraConfig{Namespace: map[string]interface{}{
+		"endpoint_disabled": true,
+		"anomalies":         map[string]interface{}{"season": "25h", "season_slots": 7.0},
+	}}, l)
+	if m.anomalies != nil {
+		t.Error("the anomalies should not be detected")
+	}
+	if !strings.Contains(buf.String(), "Invalid anomalies config, the anomalies are not detected: the season 25h0m0s can not be split in 7 slots") {
+		t.Errorf("the invalid config has not been logged: %s", buf.String())
+	}
+}
+
+func TestP95Index(t *testing.T) {
+	if p95Index < 0 || percentiles[p95Index] != 0.95 {
+		t.Errorf("unexpected position of the 95th percentile: %d", p95Index)
+	}
+}
+
+func TestAnomalyDetector(t *testing.T) {
+	registry := metrics.NewRegistry()
+	d, _ := NewAnomalyDetector(&AnomaliesConfig{Sigma: 3, Alpha: 0.1, Warmup: 10}, "krakend.", registry)
+	series := newSyntheticSeries("/foo",
+		func(minute int) float64 {
+			if minute == 200 {
+				return 300
+			}
+			return 100
+		},
+		func(minute int) float64 {
+			if minute == 200 {
+				return 0.3
+			}
+			return 0.01
+		},
+		func(minute int) float64 {
+			if minute == 200 {
+				return float64(200 * time.Millisecond)
+			}
+			return float64(50 * time.Millisecond)
+		},
+	)
+
+	for minute := 0; minute < 200; minute++ {
+		s := series.snapshot(minute)
+		if events := d.Observe(&s); len(events) != 0 {
+			t.Fatalf("unexpected anomalies at minute %d: %+v", minute, events)
+		}
+	}
+	if v := registry.Get("anomalies./foo.rate.anomalous").(metrics.Gauge).Value(); v != 0 {
+		t.Errorf("unexpected anomaly flag: %d", v)
+	}
+
+	s := series.snapshot(200)
+	events := d.Observe(&s)
+	signals := []string{AnomalyErrorRatio, AnomalyP95, AnomalyRate}
+	if len(events) != len(signals) || len(s.Anomalies) != len(signals) {
+		t.Fatalf("unexpected anomalies: %+v", events)
+	}
+	for i, signal := range signals {
+		e := events[i]
+		if e.Endpoint != "/foo" || e.Signal != signal || e.Time != s.Time || e.Score <= 3 {
+			t.Errorf("unexpected anomaly: %+v", e)
+		}
+		name := "anomalies./foo." + signal
+		if v := registry.Get(name + ".anomalous").(metrics.Gauge).Value(); v != 1 {
+			t.Errorf("unexpected flag of %s: %d", signal, v)
+		}
+		if v := registry.Get(name + ".score").(metrics.GaugeFloat64).Value(); v != e.Score {
+			t.Errorf("unexpected score of %s: %f", signal, v)
+		}
+		if v := s.Gauges["krakend.service."+name+".anomalous"]; v != 1 {
+			t.Errorf("unexpected flag of %s in the snapshot: %d", signal, v)
+		}
+		if v := s.FloatGauges["krakend.service."+name+".score"]; v != e.Score {
+			t.Errorf("unexpected score of %s in the snapshot: %f", signal, v)
+		}
+	}
+	if e := events[2]; e.Value < 290 || e.Value > 310 || e.Baseline < 98 || e.Baseline > 102 {
+		t.Errorf("unexpected rate anomaly: %+v", e)
+	}
+
+	// without traffic, the error ratio and the p95 are not observed, so their flags are cleared
+	idle := NewStats()
+	idle.Time = s.Time + int64(time.Minute)
+	for k, v := range s.Counters {
+		idle.Counters[k] = v
+	}
+	d.Observe(&idle)
+	for _, signal := range []string{AnomalyErrorRatio, AnomalyP95} {
+		name := "anomalies./foo." + signal
+		if v := registry.Get(name + ".anomalous").(metrics.Gauge).Value(); v != 0 {
+			t.Errorf("unexpected flag of the idle %s: %d", signal, v)
+		}
+		if v := registry.Get(name + ".score").(metrics.GaugeFloat64).Value(); v != 0 {
+			t.Errorf("unexpected score of the idle %s: %f", signal, v)
+		}
+		if v, ok := idle.Gauges["krakend.service."+name+".anomalous"]; !ok || v != 0 {
+			t.Errorf("unexpected flag of the idle %s in the snapshot: %d", signal, v)
+		}
+	}
+
+	var nilDetector *AnomalyDetector
+	if events := nilDetector.Observe(&s); events != nil {
+		t.Errorf("unexpected anomalies: %+v", events)
+	}
+}
+
+func TestAnomalyDetector_seasonal(t *testing.T) {
+	// the traffic is ten times higher during the first quarter of every hour
+	rate := func(minute int) float64 {
+		if minute%60 < 15 {
+			return 1000
+		}
+		return 100
+	}
+	errorRatio := func(int) float64 { return 0.01 }
+	p95 := func(int) float64 { return float64(50 * time.Millisecond) }
+
+	for _, tc := range []struct {
+		name        string
+		season      time.Duration
+		wantAnomaly bool
+	}{
+		{name: "flat", wantAnomaly: true},
+		{name: "seasonal", season: time.Hour},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d, err := NewAnomalyDetector(&AnomaliesConfig{Sigma: 3, Alpha: 0.1, Warmup: 5, Season: tc.season, SeasonSlots: 4}, "krakend.", metrics.NewRegistry())
+			if err != nil {
+				t.Fatal(err)
+			}
+			series := newSyntheticSeries("/foo", rate, errorRatio, p95)
+			anomalies := 0
+			for minute := 0; minute < 5*60; minute++ {
+				s := series.snapshot(minute)
+				for _, e := range d.Observe(&s) {
+					if e.Signal != AnomalyRate {
+						t.Errorf("unexpected anomaly at minute %d: %+v", minute, e)
+					}
+					anomalies++
+				}
+			}
+			if (anomalies > 0) != tc.wantAnomaly {
+				t.Errorf("unexpected number of anomalies: %d", anomalies)
+			}
+		})
+	}
+}
+
+// syntheticSeries generates a snapshot per minute with the responses of an endpoint following the
+// rate (requests per second), error ratio and p95 (ns) functions of the minute, plus a deterministic
+// noise of ±2%
+type syntheticSeries struct {
+	endpoint   string
+	start      time.Time
+	rate       func(minute int) float64
+	errorRatio func(minute int) float64
+	p95        func(minute int) float64
+	noise      *rand.Rand
+	ok, failed int64
+}
+
+func newSyntheticSeries(endpoint string, rate, errorRatio, p95 func(int) float64) *syntheticSeries {
+	return &syntheticSeries{
+		endpoint:   endpoint,
+		start:      time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		rate:       rate,
+		errorRatio: errorRatio,
+		p95:        p95,
+		noise:      rand.New(rand.NewPCG(1, 2)),
+	}
+}
+
+func (g *syntheticSeries) snapshot(minute int) Stats {
+	requests := g.rate(minute) * 60 * g.jitter()
+	failed := int64(requests * g.errorRatio(minute) * g.jitter())
+	g.failed += failed
+	g.ok += int64(requests) - failed
+
+	s := NewStats()
+	s.Time = g.start.Add(time.Duration(minute) * time.Minute).UnixNano()
+	s.Counters["krakend.router.response."+g.endpoint+".status.200.count"] = g.ok
+	s.Counters["krakend.router.response."+g.endpoint+".status.503.count"] = g.failed
+	p95 := g.p95(minute) * g.jitter()
+	s.Histograms["krakend.router.response."+g.endpoint+".time"] = HistogramData{
+		Max:         int64(2 * p95),
+		Percentiles: []float64{p95 / 10, p95 / 5, p95 / 2, p95 / 1.5, p95 / 1.2, p95, p95 * 1.5},
+	}
+	return s
+}
+
+func (g *syntheticSeries) jitter() float64 {
+	return 0.98 + 0.04*g.noise.Float64()
+}
@@ -0,0 +1,160 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// ApdexThresholds are the response times splitting the requests of an endpoint into satisfied (up to
+// Satisfied), tolerating (up to Tolerated) and frustrated
+type ApdexThresholds struct {
+	Satisfied time.Duration
+	Tolerated time.Duration
+}
+
+// ApdexConfig is the config of the Apdex scores of the endpoints
+type ApdexConfig struct {
+	// Default are the thresholds of the endpoints without their own ones. If it is nil, only the
+	// endpoints listed in Endpoints get a score
+	Default *ApdexThresholds
+	// Endpoints are the thresholds by endpoint
+	Endpoints map[string]ApdexThresholds
+}
+
+func parseApdexConfig(data map[string]interface{}) *ApdexConfig {
+	tmp, ok := data["apdex"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	cfg := &ApdexConfig{Endpoints: map[string]ApdexThresholds{}}
+	if t, ok := parseApdexThresholds(tmp); ok {
+		cfg.Default = &t
+	}
+	if endpoints, ok := tmp["endpoints"].(map[string]interface{}); ok {
+		for name, v := range endpoints {
+			e, ok := v.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if t, ok := parseApdexThresholds(e); ok {
+				cfg.Endpoints[name] = t
+			}
+		}
+	}
+	if cfg.Default == nil && len(cfg.Endpoints) == 0 {
+		return nil
+	}
+	return cfg
+}
+
+// parseApdexThresholds reads the satisfied and tolerated durations. The tolerated one defaults to four
+// times the satisfied one, as defined by the Apdex specification
+func parseApdexThresholds(data map[string]interface{}) (ApdexThresholds, bool) {
+	t := ApdexThresholds{}
+	s, ok := data["satisfied"].(string)
+	if !ok {
+		return t, false
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return t, false
+	}
+	t.Satisfied = d
+	t.Tolerated = 4 * d
+	if s, ok := data["tolerated"].(string); ok {
+		if d, err := time.ParseDuration(s); err == nil && d >= t.Satisfied {
+			t.Tolerated = d
+		}
+	}
+	return t, true
+}
+
+// ApdexScore returns the Apdex score of a set of response times (ns): the satisfied ones plus half of
+// the tolerating ones, divided by the total. It returns false if there are no response times
+func ApdexScore(values []int64, t ApdexThresholds) (float64, bool) {
+	if len(values) == 0 {
+		return 0, false
+	}
+	var satisfied, tolerating int
+	for _, v := range values {
+		switch {
+		case v <= int64(t.Satisfied):
+			satisfied++
+		case v <= int64(t.Tolerated):
+			tolerating++
+		}
+	}
+	return (float64(satisfied) + float64(tolerating)/2) / float64(len(values)), true
+}
+
+// Apdex computes the Apdex score of the instrumented endpoints from the response times recorded
+// during the collection interval and publishes it as the response.<endpoint>.apdex gauge
+type Apdex struct {
+	cfg      ApdexConfig
+	registry metrics.Registry
+
+	mu        sync.Mutex
+	endpoints map[string]ApdexThresholds
+}
+
+// EnableApdex enables the Apdex scores of the endpoints registered from now on
+func (rm *RouterMetrics) EnableApdex(cfg *ApdexConfig) {
+	if cfg == nil {
+		return
+	}
+	rm.apdex = &Apdex{cfg: *cfg, registry: rm.register, endpoints: map[string]ApdexThresholds{}}
+}
+
+// Apdex returns the Apdex scores of the router. It is nil if they are not enabled
+func (rm *RouterMetrics) Apdex() *Apdex {
+	return rm.apdex
+}
+
+// register adds the endpoint to the scored ones if it has thresholds. The synthetic endpoints of the
+// unmatched requests are ignored
+func (a *Apdex) register(name string) {
+	if a == nil || name == NoRouteEndpoint || name == MethodNotAllowedEndpoint {
+		return
+	}
+	t, ok := a.cfg.Endpoints[name]
+	if !ok {
+		if a.cfg.Default == nil {
+			return
+		}
+		t = *a.cfg.Default
+	}
+	a.mu.Lock()
+	a.endpoints[name] = t
+	a.mu.Unlock()
+}
+
+// Update publishes the score of every endpoint from the response times recorded since the histograms
+// were cleared. The gauges of the endpoints without requests are removed, as they have no score.
+// It does nothing on a nil Apdex
+func (a *Apdex) Update() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	endpoints := make(map[string]ApdexThresholds, len(a.endpoints))
+	for name, t := range a.endpoints {
+		endpoints[name] = t
+	}
+	a.mu.Unlock()
+
+	for name, t := range endpoints {
+		gauge := "response." + name + ".apdex"
+		h, ok := a.registry.Get("response." + name + ".time").(metrics.Histogram)
+		if !ok {
+			continue
+		}
+		score, ok := ApdexScore(h.Sample().Values(), t)
+		if !ok {
+			a.registry.Unregister(gauge)
+			continue
+		}
+		metrics.GetOrRegisterGaugeFloat64(gauge, a.registry).Update(score)
+	}
+}
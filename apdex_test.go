@@ -0,0 +1,100 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestParseApdexConfig(t *testing.T) {
+	cfg := parseApdexConfig(map[string]interface{}{
+		"apdex": map[string]interface{}{
+			"satisfied": "100ms",
+			"endpoints": map[string]interface{}{
+				"/foo": map[string]interface{}{"satisfied": "50ms", "tolerated": "100ms"},
+				"/bar": map[string]interface{}{"satisfied": "wrong"},
+			},
+		},
+	})
+	if cfg == nil {
+		t.Fatal("nil config")
+	}
+	if cfg.Default == nil || *cfg.Default != (ApdexThresholds{100 * time.Millisecond, 400 * time.Millisecond}) {
+		t.Errorf("unexpected default thresholds: %+v", cfg.Default)
+	}
+	if len(cfg.Endpoints) != 1 || cfg.Endpoints["/foo"] != (ApdexThresholds{50 * time.Millisecond, 100 * time.Millisecond}) {
+		t.Errorf("unexpected endpoint thresholds: %+v", cfg.Endpoints)
+	}
+
+	if cfg := parseApdexConfig(map[string]interface{}{"apdex": map[string]interface{}{}}); cfg != nil {
+		t.Errorf("a config without thresholds should be ignored: %+v", cfg)
+	}
+}
+
+func TestApdexScore(t *testing.T) {
+	thresholds := ApdexThresholds{Satisfied: 100, Tolerated: 400}
+	for _, tc := range []struct {
+		values []int64
+		want   float64
+	}{
+		{values: []int64{10, 100}, want: 1},
+		{values: []int64{10, 200, 400, 401}, want: 0.5},
+		{values: []int64{500, 1000}, want: 0},
+	} {
+		if score, ok := ApdexScore(tc.values, thresholds); !ok || score != tc.want {
+			t.Errorf("unexpected score for %v: %f", tc.values, score)
+		}
+	}
+	if _, ok := ApdexScore(nil, thresholds); ok {
+		t.Error("a score without values should not be defined")
+	}
+}
+
+func TestApdex_Update(t *testing.T) {
+	r := metrics.NewRegistry()
+	rm := NewRouterMetrics(&r)
+	rm.Apdex().Update()
+
+	rm.EnableApdex(&ApdexConfig{
+		Endpoints: map[string]ApdexThresholds{"/foo": {Satisfied: time.Millisecond, Tolerated: 4 * time.Millisecond}},
+	})
+	rm.RegisterResponseWriterMetrics("/foo")
+	rm.RegisterResponseWriterMetrics("/bar")
+	rm.RegisterUnmatchedMetrics()
+
+	for _, d := range []time.Duration{time.Microsecond, 2 * time.Millisecond, 3 * time.Millisecond, time.Second} {
+		rm.Histogram("response", "/foo", "time").Update(int64(d))
+		rm.Histogram("response", "/bar", "time").Update(int64(d))
+	}
+	rm.Apdex().Update()
+
+	if v := r.Get("router.response./foo.apdex").(metrics.GaugeFloat64).Value(); v != 0.5 {
+		t.Errorf("unexpected apdex: %f", v)
+	}
+	for _, name := range []string{"/bar", NoRouteEndpoint, MethodNotAllowedEndpoint} {
+		if g := r.Get("router.response." + name + ".apdex"); g != nil {
+			t.Errorf("unexpected apdex of %s: %v", name, g)
+		}
+	}
+
+	rm.Histogram("response", "/foo", "time").Clear()
+	rm.Apdex().Update()
+	if g := r.Get("router.response./foo.apdex"); g != nil {
+		t.Errorf("the apdex of an endpoint without requests should be removed: %v", g)
+	}
+}
+
+func TestMetrics_apdex(t *testing.T) {
+	p := metrics.NewRegistry()
+	m := Metrics{Registry: &p, Router: NewRouterMetrics(&p), latestSnapshot: NewStats()}
+	m.Router.EnableApdex(&ApdexConfig{Default: &ApdexThresholds{Satisfied: time.Second, Tolerated: 4 * time.Second}})
+	m.Router.RegisterResponseWriterMetrics("/foo")
+	m.Router.Histogram("response", "/foo", "time").Update(int64(time.Millisecond))
+
+	m.Router.Apdex().Update()
+	snapshot := m.TakeSnapshot()
+	if v, ok := snapshot.FloatGauges["router.response./foo.apdex"]; !ok || v != 1 {
+		t.Errorf("unexpected apdex in the snapshot: %v", snapshot.FloatGauges)
+	}
+}
@@ -0,0 +1,205 @@
+package metrics
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// AuthConfig holds the access control rules of the stats server
+type AuthConfig struct {
+	// Users maps user names to their bcrypt hashed passwords (basic auth)
+	Users map[string]string
+	// TokensFile is the path of a file with a static bearer token per line
+	TokensFile string
+	// AllowedCIDRs is the list of networks allowed to reach the stats server
+	AllowedCIDRs []string
+}
+
+func parseAuthConfig(data map[string]interface{}) *AuthConfig {
+	v, ok := data["auth"]
+	if !ok {
+		return nil
+	}
+	tmp, ok := v.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	cfg := &AuthConfig{Users: map[string]string{}}
+	if users, ok := tmp["users"].(map[string]interface{}); ok {
+		for user, hash := range users {
+			if h, ok := hash.(string); ok {
+				cfg.Users[user] = h
+			}
+		}
+	}
+	if tokensFile, ok := tmp["tokens_file"].(string); ok {
+		cfg.TokensFile = tokensFile
+	}
+	if cidrs, ok := tmp["allowed_cidrs"].([]interface{}); ok {
+		for _, cidr := range cidrs {
+			if c, ok := cidr.(string); ok {
+				cfg.AllowedCIDRs = append(cfg.AllowedCIDRs, c)
+			}
+		}
+	}
+	return cfg
+}
+
+// NewAuthenticator creates an Authenticator enforcing the received rules. A nil config returns a nil
+// Authenticator, that allows every request
+func NewAuthenticator(cfg *AuthConfig) (*Authenticator, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+
+	a := &Authenticator{users: map[string][]byte{}}
+	maxCost := 0
+	for user, hash := range cfg.Users {
+		cost, err := bcrypt.Cost([]byte(hash))
+		if err != nil {
+			return nil, fmt.Errorf("invalid bcrypt hash for the user %s: %w", user, err)
+		}
+		a.users[user] = []byte(hash)
+		maxCost = max(maxCost, cost)
+	}
+	if maxCost > 0 {
+		// the unknown users are checked against a dummy hash as expensive as the real ones, so the
+		// response time does not reveal which users exist
+		dummy, err := bcrypt.GenerateFromPassword([]byte("dummy"), maxCost)
+		if err != nil {
+			return nil, err
+		}
+		a.dummyHash = dummy
+	}
+
+	for _, cidr := range cfg.AllowedCIDRs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		a.networks = append(a.networks, network)
+	}
+
+	if cfg.TokensFile == "" {
+		return a, nil
+	}
+	f, err := os.Open(cfg.TokensFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		token := strings.TrimSpace(scanner.Text())
+		if token == "" || strings.HasPrefix(token, "#") {
+			continue
+		}
+		digest := sha256.Sum256([]byte(token))
+		a.tokens = append(a.tokens, digest[:])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(a.tokens) == 0 {
+		return nil, errors.New("no tokens found in " + cfg.TokensFile)
+	}
+	return a, nil
+}
+
+// Authenticator checks the requests against the access control rules of the stats server. The network
+// allowlist is always enforced; if basic auth users or bearer tokens are defined, every request must
+// present valid credentials for one of them
+type Authenticator struct {
+	users     map[string][]byte
+	dummyHash []byte
+	tokens    [][]byte
+	networks  []*net.IPNet
+	denyAll   bool
+}
+
+// Authorize returns the status code the stats server should reply with when the request is not
+// authorized, or http.StatusOK if it is
+func (a *Authenticator) Authorize(r *http.Request) int {
+	if a == nil {
+		return http.StatusOK
+	}
+	if a.denyAll || !a.allowedNetwork(r.RemoteAddr) {
+		return http.StatusForbidden
+	}
+	if len(a.users) == 0 && len(a.tokens) == 0 {
+		return http.StatusOK
+	}
+
+	if user, pass, ok := r.BasicAuth(); ok {
+		hash, ok := a.users[user]
+		if !ok {
+			hash = a.dummyHash
+		}
+		if bcrypt.CompareHashAndPassword(hash, []byte(pass)) == nil && ok {
+			return http.StatusOK
+		}
+		return http.StatusUnauthorized
+	}
+
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		digest := sha256.Sum256([]byte(token))
+		for _, t := range a.tokens {
+			if subtle.ConstantTimeCompare(t, digest[:]) == 1 {
+				return http.StatusOK
+			}
+		}
+	}
+	return http.StatusUnauthorized
+}
+
+// Handler wraps the received handler, rejecting the requests not passing the access control rules
+func (a *Authenticator) Handler(h http.Handler) http.Handler {
+	if a == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status := a.Authorize(r); status != http.StatusOK {
+			a.Reject(w, status)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Reject writes the response for a request that did not pass the access control rules
+func (a *Authenticator) Reject(w http.ResponseWriter, status int) {
+	if status == http.StatusUnauthorized && len(a.users) > 0 {
+		w.Header().Set("WWW-Authenticate", `Basic realm="stats"`)
+	}
+	http.Error(w, http.StatusText(status), status)
+}
+
+func (a *Authenticator) allowedNetwork(remoteAddr string) bool {
+	if len(a.networks) == 0 {
+		return true
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range a.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestNewAuthenticator(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	tokensFile := filepath.Join(t.TempDir(), "tokens")
+	os.WriteFile(tokensFile, []byte("# scrapers\ntoken-1\n\n  token-2  \n"), 0600)
+
+	cfg := ConfigGetter(map[string]interface{}{
+		Namespace: map[string]interface{}{
+			"auth": map[string]interface{}{
+				"users":         map[string]interface{}{"admin": string(hash)},
+				"tokens_file":   tokensFile,
+				"allowed_cidrs": []interface{}{"10.0.0.0/8", "::1/128"},
+			},
+		},
+	}).(*Config)
+
+	auth, err := NewAuthenticator(cfg.Auth)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for i, tc := range []struct {
+		remoteAddr string
+		user, pass string
+		token      string
+		status     int
+	}{
+		{remoteAddr: "192.168.1.1:1234", token: "token-1", status: http.StatusForbidden},
+		{remoteAddr: "10.1.2.3:1234", status: http.StatusUnauthorized},
+		{remoteAddr: "10.1.2.3:1234", token: "token-1", status: http.StatusOK},
+		{remoteAddr: "[::1]:1234", token: "token-2", status: http.StatusOK},
+		{remoteAddr: "10.1.2.3:1234", token: "token-3", status: http.StatusUnauthorized},
+		{remoteAddr: "10.1.2.3:1234", user: "admin", pass: "secret", status: http.StatusOK},
+		{remoteAddr: "10.1.2.3:1234", user: "admin", pass: "wrong", status: http.StatusUnauthorized},
+		{remoteAddr: "10.1.2.3:1234", user: "nobody", pass: "secret", status: http.StatusUnauthorized},
+		{remoteAddr: "10.1.2.3:1234", user: "nobody", pass: "dummy", status: http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest("GET", "/__stats", http.NoBody)
+		req.RemoteAddr = tc.remoteAddr
+		if tc.user != "" {
+			req.SetBasicAuth(tc.user, tc.pass)
+		}
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		if status := auth.Authorize(req); status != tc.status {
+			t.Errorf("%d: unexpected status. have: %d, want: %d", i, status, tc.status)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/__stats", http.NoBody)
+	req.RemoteAddr = "10.1.2.3:1234"
+	auth.Handler(http.NotFoundHandler()).ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("unexpected status code: %d", w.Code)
+	}
+	if w.Header().Get("WWW-Authenticate") == "" {
+		t.Error("the basic auth challenge should be present")
+	}
+}
+
+func TestNewAuthenticator_dummyHash(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost+1)
+	auth, err := NewAuthenticator(&AuthConfig{Users: map[string]string{"admin": string(hash)}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	// the unknown users must pay the same bcrypt cost as the known ones
+	if cost, err := bcrypt.Cost(auth.dummyHash); err != nil || cost != bcrypt.MinCost+1 {
+		t.Errorf("unexpected cost of the dummy hash: %d (%v)", cost, err)
+	}
+}
+
+func TestNewAuthenticator_ko(t *testing.T) {
+	for i, cfg := range []*AuthConfig{
+		{Users: map[string]string{"admin": "plain-text"}},
+		{AllowedCIDRs: []string{"10.0.0.0/33"}},
+		{TokensFile: filepath.Join(t.TempDir(), "unknown")},
+	} {
+		if _, err := NewAuthenticator(cfg); err == nil {
+			t.Errorf("%d: error expected", i)
+		}
+	}
+
+	var auth *Authenticator
+	if status := auth.Authorize(httptest.NewRequest("GET", "/", http.NoBody)); status != http.StatusOK {
+		t.Errorf("a nil authenticator should allow every request. status: %d", status)
+	}
+}
@@ -0,0 +1,245 @@
+package metrics
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+const (
+	// defaultMaxConsumers is the max number of consumers tracked when the config does not define it
+	defaultMaxConsumers = 1000
+	// defaultConsumerIdleTimeout is the inactivity freeing the slot of a consumer when the config does
+	// not define it
+	defaultConsumerIdleTimeout = time.Hour
+	// AnonymousConsumer is the consumer used for the requests without identity
+	AnonymousConsumer = "__anonymous"
+	// OtherConsumer is the consumer used for the identities exceeding the max number of consumers
+	OtherConsumer = "__other"
+)
+
+// ConsumerConfig defines how to extract the identity of the consumer from the requests. The sources
+// are checked in order (header, query string, JWT claim) and the first non-empty value is used
+type ConsumerConfig struct {
+	Header      string
+	QueryString string
+	// JWTHeader is the header containing the JWT holding the JWTClaim. The "Bearer" prefix is optional.
+	// The signature of the token is NOT verified, so this source is only safe behind a validator
+	// rejecting the invalid tokens before the instrumented handlers (like krakend-jose). Prefer reading
+	// the claim from the Header the validator propagates it to (ex: propagate_claims in krakend-jose)
+	JWTHeader string
+	JWTClaim  string
+	// Hash replaces the identities with their hash, so secrets like API keys are not exposed. The config
+	// parser enables it unless "hash" is false
+	Hash bool
+	// MaxConsumers is the max number of distinct consumers tracked
+	MaxConsumers int
+	// IdleTimeout is the inactivity after which the slot of a consumer can be given to a new one
+	IdleTimeout time.Duration
+}
+
+func parseConsumerConfig(data map[string]interface{}) *ConsumerConfig {
+	tmp, ok := data["consumers"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	cfg := &ConsumerConfig{Hash: true, MaxConsumers: defaultMaxConsumers, IdleTimeout: defaultConsumerIdleTimeout}
+	cfg.Header, _ = tmp["header"].(string)
+	cfg.QueryString, _ = tmp["query_string"].(string)
+	cfg.JWTHeader, _ = tmp["jwt_header"].(string)
+	cfg.JWTClaim, _ = tmp["jwt_claim"].(string)
+	if cfg.JWTClaim != "" && cfg.JWTHeader == "" {
+		cfg.JWTHeader = "Authorization"
+	}
+	if v, ok := tmp["hash"].(bool); ok {
+		cfg.Hash = v
+	}
+	if v, ok := tmp["max_consumers"].(float64); ok && v > 0 {
+		cfg.MaxConsumers = int(v)
+	}
+	if v, ok := tmp["idle_timeout"].(string); ok {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			cfg.IdleTimeout = d
+		}
+	}
+	if cfg.Header == "" && cfg.QueryString == "" && cfg.JWTClaim == "" {
+		return nil
+	}
+	return cfg
+}
+
+type consumers struct {
+	cfg      ConsumerConfig
+	register metrics.Registry
+	mu       sync.RWMutex
+	// seen holds the last time (unix ns) every tracked consumer got a response
+	seen map[string]*atomic.Int64
+	// nextEviction is the earliest time a slot can be idle enough to be freed
+	nextEviction time.Time
+}
+
+// EnableConsumerMetrics enables the per consumer metrics of the router
+func (rm *RouterMetrics) EnableConsumerMetrics(cfg *ConsumerConfig) {
+	if cfg == nil {
+		return
+	}
+	c := *cfg
+	if c.IdleTimeout <= 0 {
+		c.IdleTimeout = defaultConsumerIdleTimeout
+	}
+	rm.consumers = &consumers{cfg: c, register: rm.register, seen: map[string]*atomic.Int64{}}
+}
+
+// Consumer returns the identity of the consumer of the request (hashed if configured, and with the chars
+// not allowed in a metric name segment replaced otherwise), AnonymousConsumer if the request has no
+// identity or an empty string if the consumer metrics are not enabled. The identity gets its own
+// metrics once ConsumerResponse records a successful response for it
+func (rm *RouterMetrics) Consumer(r *http.Request) string {
+	if rm.consumers == nil {
+		return ""
+	}
+	id := rm.consumers.identity(r)
+	if id == "" {
+		return AnonymousConsumer
+	}
+	if rm.consumers.cfg.Hash {
+		sum := sha256.Sum256([]byte(id))
+		return hex.EncodeToString(sum[:8])
+	}
+	return sanitizeSegment(id)
+}
+
+func (c *consumers) identity(r *http.Request) string {
+	if c.cfg.Header != "" {
+		if v := r.Header.Get(c.cfg.Header); v != "" {
+			return v
+		}
+	}
+	if c.cfg.QueryString != "" {
+		if v := r.URL.Query().Get(c.cfg.QueryString); v != "" {
+			return v
+		}
+	}
+	if c.cfg.JWTClaim != "" {
+		return jwtClaim(r.Header.Get(c.cfg.JWTHeader), c.cfg.JWTClaim)
+	}
+	return ""
+}
+
+// track returns the name the response of the consumer is recorded with. Only the successful responses
+// (status codes below 400) get a slot for a new consumer, so the rejected requests can not fill the
+// table with unverified identities. Once the max number of consumers is reached, the slot of the least
+// recently seen consumer is freed if it has been idle for IdleTimeout, and its metrics are removed.
+// Otherwise, the consumer is recorded as OtherConsumer
+func (c *consumers) track(id string, successful bool, now time.Time) string {
+	if id == AnonymousConsumer || id == OtherConsumer {
+		return id
+	}
+	c.mu.RLock()
+	lastSeen, ok := c.seen[id]
+	c.mu.RUnlock()
+	if ok {
+		lastSeen.Store(now.UnixNano())
+		return id
+	}
+	if !successful {
+		return OtherConsumer
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if lastSeen, ok := c.seen[id]; ok {
+		lastSeen.Store(now.UnixNano())
+		return id
+	}
+	if len(c.seen) >= c.cfg.MaxConsumers && !c.evictIdle(now) {
+		return OtherConsumer
+	}
+	lastSeen = new(atomic.Int64)
+	lastSeen.Store(now.UnixNano())
+	c.seen[id] = lastSeen
+	return id
+}
+
+// evictIdle frees the slot of the least recently seen consumer if it has been idle for IdleTimeout. The
+// caller must hold the lock
+func (c *consumers) evictIdle(now time.Time) bool {
+	if now.Before(c.nextEviction) {
+		return false
+	}
+	oldest, oldestSeen := "", int64(math.MaxInt64)
+	for id, lastSeen := range c.seen {
+		if v := lastSeen.Load(); v < oldestSeen {
+			oldest, oldestSeen = id, v
+		}
+	}
+	if idle := time.Unix(0, oldestSeen).Add(c.cfg.IdleTimeout); now.Before(idle) {
+		c.nextEviction = idle
+		return false
+	}
+	delete(c.seen, oldest)
+	for _, name := range []string{"requests", "errors", "bytes", "time"} {
+		c.register.Unregister("consumer." + oldest + "." + name)
+	}
+	return true
+}
+
+// jwtClaim returns the value of the claim of the token, without verifying its signature: anyone can
+// forge it, so the token must be validated before reaching the instrumented handlers. It returns an
+// empty string if the token is malformed or the claim is not a string or a number
+func jwtClaim(token, claim string) string {
+	token = strings.TrimSpace(token)
+	if len(token) > 7 && strings.EqualFold(token[:7], "bearer ") {
+		token = strings.TrimSpace(token[7:])
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return ""
+	}
+	claims := map[string]interface{}{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return ""
+	}
+	switch v := claims[claim].(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return ""
+}
+
+// ConsumerResponse records a response served to the consumer: the number of requests, the errors
+// (status codes equal or above 400), the bytes sent and the response time. It does nothing when the
+// consumer is empty. When the consumer metrics are enabled, the consumers without a slot are recorded
+// as OtherConsumer
+func (rm *RouterMetrics) ConsumerResponse(consumer string, status int, size int64, d time.Duration) {
+	if consumer == "" {
+		return
+	}
+	if rm.consumers != nil {
+		consumer = rm.consumers.track(consumer, status < http.StatusBadRequest, rm.Now())
+	}
+	rm.Counter("consumer", consumer, "requests").Inc(1)
+	if status >= http.StatusBadRequest {
+		rm.Counter("consumer", consumer, "errors").Inc(1)
+	}
+	if size > 0 {
+		rm.Counter("consumer", consumer, "bytes").Inc(size)
+	}
+	rm.Histogram("consumer", consumer, "time").Update(int64(d))
+}
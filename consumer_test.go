@@ -0,0 +1,160 @@
+package metrics
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestParseConsumerConfig(t *testing.T) {
+	cfg := parseConsumerConfig(map[string]interface{}{
+		"consumers": map[string]interface{}{
+			"header":        "X-Api-Key",
+			"jwt_claim":     "sub",
+			"hash":          false,
+			"max_consumers": 10.0,
+			"idle_timeout":  "10m",
+		},
+	})
+	want := ConsumerConfig{Header: "X-Api-Key", JWTHeader: "Authorization", JWTClaim: "sub", MaxConsumers: 10, IdleTimeout: 10 * time.Minute}
+	if cfg == nil || *cfg != want {
+		t.Errorf("unexpected config: %+v", cfg)
+	}
+	cfg = parseConsumerConfig(map[string]interface{}{"consumers": map[string]interface{}{"header": "X-Api-Key"}})
+	want = ConsumerConfig{Header: "X-Api-Key", Hash: true, MaxConsumers: defaultMaxConsumers, IdleTimeout: defaultConsumerIdleTimeout}
+	if cfg == nil || *cfg != want {
+		t.Errorf("unexpected default config: %+v", cfg)
+	}
+	if cfg := parseConsumerConfig(map[string]interface{}{"consumers": map[string]interface{}{}}); cfg != nil {
+		t.Errorf("a config without sources should be ignored: %+v", cfg)
+	}
+}
+
+func TestRouterMetrics_Consumer(t *testing.T) {
+	r := metrics.NewRegistry()
+	rm := NewRouterMetrics(&r)
+
+	req := httptest.NewRequest(http.MethodGet, "/foo?key=query-key", http.NoBody)
+	if c := rm.Consumer(req); c != "" {
+		t.Errorf("unexpected consumer with the consumer metrics disabled: %s", c)
+	}
+
+	rm.EnableConsumerMetrics(&ConsumerConfig{
+		Header:       "X-Api-Key",
+		QueryString:  "key",
+		JWTHeader:    "Authorization",
+		JWTClaim:     "sub",
+		MaxConsumers: 3,
+	})
+	token := "xxx." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"jwt-user","iat":1}`)) + ".yyy"
+
+	for _, tc := range []struct {
+		header, auth, query string
+		want                string
+	}{
+		{header: "header-key", auth: "Bearer " + token, query: "query-key", want: "header-key"},
+		{auth: "Bearer " + token, query: "query-key", want: "query-key"},
+		{auth: "bearer " + token, want: "jwt-user"},
+		{auth: token, want: "jwt-user"},
+		{auth: "Bearer not-a-jwt", want: AnonymousConsumer},
+		{want: AnonymousConsumer},
+		// the chars not allowed in a segment of a metric name are replaced
+		{header: "acme.corp/key", want: "acme_corp_key"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/foo", http.NoBody)
+		if tc.query != "" {
+			req.URL.RawQuery = "key=" + tc.query
+		}
+		if tc.header != "" {
+			req.Header.Set("X-Api-Key", tc.header)
+		}
+		if tc.auth != "" {
+			req.Header.Set("Authorization", tc.auth)
+		}
+		if c := rm.Consumer(req); c != tc.want {
+			t.Errorf("want %s, have %s", tc.want, c)
+		}
+	}
+
+	rm.EnableConsumerMetrics(&ConsumerConfig{Header: "X-Api-Key", Hash: true, MaxConsumers: 3})
+	req.Header.Set("X-Api-Key", "secret")
+	if c := rm.Consumer(req); c != "2bb80d537b1da3e3" {
+		t.Errorf("unexpected hashed consumer: %s", c)
+	}
+}
+
+func TestRouterMetrics_ConsumerResponse_slots(t *testing.T) {
+	r := metrics.NewRegistry()
+	rm := NewRouterMetrics(&r)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	rm.SetClock(func() time.Time { return now })
+	rm.EnableConsumerMetrics(&ConsumerConfig{Header: "X-Api-Key", MaxConsumers: 2, IdleTimeout: time.Hour})
+
+	count := func(consumer string) int64 {
+		if c, ok := r.Get("router.consumer." + consumer + ".requests").(metrics.Counter); ok {
+			return c.Count()
+		}
+		return 0
+	}
+
+	// the rejected requests do not get a slot
+	rm.ConsumerResponse("junk-1", http.StatusUnauthorized, 0, time.Millisecond)
+	rm.ConsumerResponse("junk-2", http.StatusForbidden, 0, time.Millisecond)
+	if v := count(OtherConsumer); v != 2 {
+		t.Errorf("unexpected requests of %s: %d", OtherConsumer, v)
+	}
+	rm.ConsumerResponse("acme", http.StatusOK, 0, time.Millisecond)
+	rm.ConsumerResponse("acme", http.StatusTooManyRequests, 0, time.Millisecond)
+	rm.ConsumerResponse(AnonymousConsumer, http.StatusOK, 0, time.Millisecond)
+	now = now.Add(30 * time.Minute)
+	rm.ConsumerResponse("globex", http.StatusOK, 0, time.Millisecond)
+	if count("acme") != 2 || count("globex") != 1 || count(AnonymousConsumer) != 1 || count("junk-1") != 0 {
+		t.Errorf("unexpected consumers: %v", r.GetAll())
+	}
+
+	// the table is full and no consumer has been idle for long enough
+	rm.ConsumerResponse("initech", http.StatusOK, 0, time.Millisecond)
+	if count("initech") != 0 || count(OtherConsumer) != 3 {
+		t.Errorf("unexpected consumers: %v", r.GetAll())
+	}
+
+	// acme has been idle for an hour, so its slot is given to initech
+	now = now.Add(31 * time.Minute)
+	rm.ConsumerResponse("initech", http.StatusOK, 0, time.Millisecond)
+	if count("initech") != 1 || count("globex") != 1 {
+		t.Errorf("unexpected consumers: %v", r.GetAll())
+	}
+	if r.Get("router.consumer.acme.requests") != nil || r.Get("router.consumer.acme.time") != nil {
+		t.Error("the metrics of the evicted consumer should be removed")
+	}
+}
+
+func TestRouterMetrics_ConsumerResponse(t *testing.T) {
+	r := metrics.NewRegistry()
+	rm := NewRouterMetrics(&r)
+
+	rm.ConsumerResponse("", 200, 10, time.Millisecond)
+	rm.ConsumerResponse("acme", 200, 10, time.Millisecond)
+	rm.ConsumerResponse("acme", 429, 5, time.Millisecond)
+	rm.ConsumerResponse("acme", 500, -1, time.Millisecond)
+
+	for name, want := range map[string]int64{
+		"router.consumer.acme.requests": 3,
+		"router.consumer.acme.errors":   2,
+		"router.consumer.acme.bytes":    15,
+	} {
+		if v := r.Get(name).(metrics.Counter).Count(); v != want {
+			t.Errorf("%s: want %d, have %d", name, want, v)
+		}
+	}
+	if v := r.Get("router.consumer.acme.time").(metrics.Histogram).Count(); v != 3 {
+		t.Errorf("unexpected number of response times: %d", v)
+	}
+	if r.Get("router.consumer..requests") != nil {
+		t.Error("the responses without consumer should not be recorded")
+	}
+}
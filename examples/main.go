@@ -49,7 +49,7 @@ func main() {
 
 	if *useGorilla {
 
-		metric := metricsmux.New(ctx, serviceConfig.ExtraConfig, logger)
+		metric := metricsmux.NewFromServiceConfig(ctx, serviceConfig, logger)
 
 		// create a new proxy factory wrapping an instrumented HTTP backend factory
 		pf := proxy.NewDefaultFactory(metric.DefaultBackendFactory(), logger)
@@ -65,12 +65,14 @@ func main() {
 
 	} else {
 
-		metric := metricsgin.New(ctx, serviceConfig.ExtraConfig, logger)
+		metric := metricsgin.NewFromServiceConfig(ctx, serviceConfig, logger)
 
 		// create a new proxy factory wrapping an instrumented HTTP backend factory
 		pf := proxy.NewDefaultFactory(metric.DefaultBackendFactory(), logger)
 
 		engine := gin.Default()
+		// instrument the unmatched routes and the recovered panics
+		engine.Use(metric.NewRouterMiddleware())
 		routerFactory := krakendgin.NewFactory(krakendgin.Config{
 			// declare the instrumented router handler
 			HandlerFactory: metric.NewHTTPHandlerFactory(krakendgin.EndpointHandler),
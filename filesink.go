@@ -0,0 +1,291 @@
+package metrics
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/luraproject/lura/v2/logging"
+)
+
+// FileSinkName is the name of the NDJSON file sink in the "sinks" object of the config
+const FileSinkName = "file"
+
+func init() {
+	RegisterSink(FileSinkName, func(cfg map[string]interface{}, _ logging.Logger) (Sink, error) {
+		c, err := parseFileSinkConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return NewFileSink(c), nil
+	})
+}
+
+// The fsync policies of the file sink
+const (
+	// FsyncNever leaves the flushing of the written data to the OS
+	FsyncNever = "never"
+	// FsyncRotate syncs the file before rotating or closing it
+	FsyncRotate = "rotate"
+	// FsyncAlways syncs the file after every snapshot
+	FsyncAlways = "always"
+)
+
+// rotatedTimeFormat is the suffix added to the rotated files. It keeps them sorted by rotation time
+const rotatedTimeFormat = "20060102T150405.000000000"
+
+// FileSinkConfig is the config of the file sink
+type FileSinkConfig struct {
+	// Path of the file receiving the snapshots
+	Path string
+	// MaxSize is the size in bytes triggering the rotation of the file. Zero disables it
+	MaxSize int64
+	// MaxAge is the age triggering the rotation of the file. Zero disables it
+	MaxAge time.Duration
+	// MaxFiles is the number of rotated files to keep. Zero keeps all of them
+	MaxFiles int
+	// Compress gzips the rotated files
+	Compress bool
+	// Fsync is the fsync policy: never, rotate or always
+	Fsync string
+}
+
+func parseFileSinkConfig(data map[string]interface{}) (FileSinkConfig, error) {
+	cfg := FileSinkConfig{Fsync: FsyncRotate}
+	cfg.Path, _ = data["path"].(string)
+	if cfg.Path == "" {
+		return cfg, errors.New("the file sink requires a path")
+	}
+	if v, ok := data["max_size"].(float64); ok && v > 0 {
+		cfg.MaxSize = int64(v)
+	}
+	if v, ok := data["max_age"].(string); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid max_age: %w", err)
+		}
+		cfg.MaxAge = d
+	}
+	if v, ok := data["max_files"].(float64); ok && v > 0 {
+		cfg.MaxFiles = int(v)
+	}
+	cfg.Compress = getBool(data, "compress")
+	if v, ok := data["fsync"].(string); ok {
+		switch v {
+		case FsyncNever, FsyncRotate, FsyncAlways:
+			cfg.Fsync = v
+		default:
+			return cfg, fmt.Errorf("unknown fsync policy %q", v)
+		}
+	}
+	return cfg, nil
+}
+
+// NewFileSink returns a sink appending every snapshot as a JSON line to the file at cfg.Path
+func NewFileSink(cfg FileSinkConfig) Sink {
+	return &fileSink{cfg: cfg, now: time.Now}
+}
+
+type fileSink struct {
+	cfg      FileSinkConfig
+	now      func() time.Time
+	f        *os.File
+	size     int64
+	openedAt time.Time
+}
+
+func (s *fileSink) Start(_ context.Context) error {
+	if err := os.MkdirAll(filepath.Dir(s.cfg.Path), 0o755); err != nil {
+		return err
+	}
+	return s.open()
+}
+
+func (s *fileSink) open() error {
+	f, err := os.OpenFile(s.cfg.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.f = f
+	s.size = info.Size()
+	s.openedAt = s.now()
+	return nil
+}
+
+func (s *fileSink) Export(_ context.Context, stats Stats) error {
+	if s.f == nil {
+		return os.ErrClosed
+	}
+	line, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if s.shouldRotate(int64(len(line))) {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.f.Write(line)
+	s.size += int64(n)
+	if err != nil {
+		return err
+	}
+	if s.cfg.Fsync == FsyncAlways {
+		return s.f.Sync()
+	}
+	return nil
+}
+
+func (s *fileSink) shouldRotate(next int64) bool {
+	if s.size == 0 {
+		return false
+	}
+	if s.cfg.MaxSize > 0 && s.size+next > s.cfg.MaxSize {
+		return true
+	}
+	return s.cfg.MaxAge > 0 && s.now().Sub(s.openedAt) >= s.cfg.MaxAge
+}
+
+func (s *fileSink) rotate() error {
+	openedAt := s.openedAt
+	if err := s.closeFile(); err != nil {
+		return errors.Join(err, s.reopen(openedAt))
+	}
+	rotated := s.cfg.Path + "." + s.now().UTC().Format(rotatedTimeFormat)
+	if err := os.Rename(s.cfg.Path, rotated); err != nil {
+		return errors.Join(err, s.reopen(openedAt))
+	}
+	if err := s.open(); err != nil {
+		return err
+	}
+	if s.cfg.Compress {
+		if err := compressFile(rotated); err != nil {
+			return err
+		}
+	}
+	return s.removeOldFiles()
+}
+
+// reopen opens the current file again after a failed rotation, so the sink keeps appending to it and
+// retries the rotation on the next export
+func (s *fileSink) reopen(openedAt time.Time) error {
+	if err := s.open(); err != nil {
+		return err
+	}
+	s.openedAt = openedAt
+	return nil
+}
+
+func (s *fileSink) closeFile() error {
+	f := s.f
+	s.f = nil
+	if s.cfg.Fsync != FsyncNever {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// removeOldFiles deletes the oldest rotated files exceeding the max number of files
+func (s *fileSink) removeOldFiles() error {
+	if s.cfg.MaxFiles <= 0 {
+		return nil
+	}
+	dir, base := filepath.Split(s.cfg.Path)
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	rotated := []string{}
+	for _, e := range entries {
+		if !e.IsDir() && isRotatedFile(base, e.Name()) {
+			rotated = append(rotated, e.Name())
+		}
+	}
+	if len(rotated) <= s.cfg.MaxFiles {
+		return nil
+	}
+	sort.Strings(rotated)
+	for _, name := range rotated[:len(rotated)-s.cfg.MaxFiles] {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isRotatedFile returns whether the name is the one of a rotated file of the base file name: the base
+// followed by the rotation time and, if compressed, the .gz extension
+func isRotatedFile(base, name string) bool {
+	suffix, ok := strings.CutPrefix(name, base+".")
+	if !ok {
+		return false
+	}
+	_, err := time.Parse(rotatedTimeFormat, strings.TrimSuffix(suffix, ".gz"))
+	return err == nil
+}
+
+func compressFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	err = writeGzip(name+".gz", src)
+	src.Close()
+	if err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
+
+func writeGzip(name string, r io.Reader) error {
+	dst, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, r); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+func (s *fileSink) Flush(_ context.Context) error {
+	if s.f == nil || s.cfg.Fsync == FsyncNever {
+		return nil
+	}
+	return s.f.Sync()
+}
+
+func (s *fileSink) Close() error {
+	if s.f == nil {
+		return nil
+	}
+	return s.closeFile()
+}
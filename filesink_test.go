@@ -0,0 +1,198 @@
+package metrics
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestParseFileSinkConfig(t *testing.T) {
+	cfg, err := parseFileSinkConfig(map[string]interface{}{
+		"path":      "/var/log/stats.ndjson",
+		"max_size":  1024.0,
+		"max_age":   "1h",
+		"max_files": 3.0,
+		"compress":  true,
+		"fsync":     "always",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := FileSinkConfig{
+		Path:     "/var/log/stats.ndjson",
+		MaxSize:  1024,
+		MaxAge:   time.Hour,
+		MaxFiles: 3,
+		Compress: true,
+		Fsync:    FsyncAlways,
+	}
+	if cfg != want {
+		t.Errorf("unexpected config: %+v", cfg)
+	}
+
+	if cfg, _ := parseFileSinkConfig(map[string]interface{}{"path": "a"}); cfg.Fsync != FsyncRotate {
+		t.Errorf("unexpected default fsync policy: %s", cfg.Fsync)
+	}
+	for _, data := range []map[string]interface{}{
+		{},
+		{"path": "a", "max_age": "soon"},
+		{"path": "a", "fsync": "sometimes"},
+	} {
+		if _, err := parseFileSinkConfig(data); err == nil {
+			t.Errorf("error expected for %v", data)
+		}
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "stats", "stats.ndjson")
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := &fileSink{
+		cfg: FileSinkConfig{Path: path, MaxSize: 200, MaxAge: time.Minute, MaxFiles: 1, Compress: true, Fsync: FsyncAlways},
+		now: func() time.Time { return now },
+	}
+	ctx := context.Background()
+	if err := s.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// the files sharing the prefix of the sink but not being rotated files must be kept
+	for _, name := range []string{"stats.ndjson.bak", "stats.ndjson.state"} {
+		os.WriteFile(filepath.Join(dir, "stats", name), []byte("keep"), 0o644)
+	}
+
+	snapshot := func(i int64) Stats {
+		stats := NewStats()
+		stats.Time = i
+		stats.Counters["krakend.router.connected-total"] = i
+		return stats
+	}
+	// every line is ~100 bytes, so the size limit rotates the file every 2 snapshots
+	for i := int64(0); i < 4; i++ {
+		now = now.Add(time.Second)
+		if err := s.Export(ctx, snapshot(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the age limit rotates the file with a single snapshot
+	now = now.Add(time.Minute)
+	if err := s.Export(ctx, snapshot(4)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Flush(ctx); err != nil {
+		t.Error(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Error(err)
+	}
+
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	names := []string{}
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	// the first rotated file is removed, since only one is retained
+	if len(names) != 4 || names[0] != "stats.ndjson" || names[1] != "stats.ndjson.20240101T000104.000000000.gz" ||
+		names[2] != "stats.ndjson.bak" || names[3] != "stats.ndjson.state" {
+		t.Fatalf("unexpected files: %v", names)
+	}
+	if times := readNDJSON(t, filepath.Join(dir, "stats", names[1]), true); len(times) != 2 || times[0] != 2 || times[1] != 3 {
+		t.Errorf("unexpected content of the rotated file: %v", times)
+	}
+	if times := readNDJSON(t, path, false); len(times) != 1 || times[0] != 4 {
+		t.Errorf("unexpected content of the current file: %v", times)
+	}
+
+	if err := s.Export(ctx, snapshot(5)); err != os.ErrClosed {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestFileSink_renameError(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "stats.ndjson")
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := &fileSink{
+		cfg: FileSinkConfig{Path: path, MaxAge: time.Minute, Fsync: FsyncNever},
+		now: func() time.Time { return now },
+	}
+	ctx := context.Background()
+	if err := s.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Export(ctx, Stats{Time: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a non empty directory with the name of the rotated file makes the rename fail
+	now = now.Add(time.Minute)
+	blocker := path + "." + now.UTC().Format(rotatedTimeFormat)
+	os.MkdirAll(filepath.Join(blocker, "child"), 0o755)
+	if err := s.Export(ctx, Stats{Time: 2}); err == nil {
+		t.Error("the failed rotation should be reported")
+	}
+
+	now = now.Add(time.Second)
+	if err := s.Export(ctx, Stats{Time: 3}); err != nil {
+		t.Fatalf("the sink should recover from the failed rotation: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Error(err)
+	}
+	if times := readNDJSON(t, path, false); len(times) != 1 || times[0] != 3 {
+		t.Errorf("unexpected content of the current file: %v", times)
+	}
+	rotated := path + "." + now.UTC().Format(rotatedTimeFormat)
+	if times := readNDJSON(t, rotated, false); len(times) != 1 || times[0] != 1 {
+		t.Errorf("unexpected content of the rotated file: %v", times)
+	}
+}
+
+func TestIsRotatedFile(t *testing.T) {
+	for name, want := range map[string]bool{
+		"stats.ndjson.20240101T000104.000000000":    true,
+		"stats.ndjson.20240101T000104.000000000.gz": true,
+		"stats.ndjson":     false,
+		"stats.ndjson.bak": false,
+		"stats.ndjson.20240101T000104.000000000.tmp": false,
+		"other.ndjson.20240101T000104.000000000":     false,
+	} {
+		if have := isRotatedFile("stats.ndjson", name); have != want {
+			t.Errorf("%s: unexpected result. have: %v, want: %v", name, have, want)
+		}
+	}
+}
+
+func readNDJSON(t *testing.T, name string, compressed bool) []int64 {
+	t.Helper()
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	if compressed {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sc = bufio.NewScanner(gz)
+	}
+	res := []int64{}
+	for sc.Scan() {
+		var stats Stats
+		if err := json.Unmarshal(sc.Bytes(), &stats); err != nil {
+			t.Fatal(err)
+		}
+		res = append(res, stats.Time)
+	}
+	return res
+}
@@ -0,0 +1,136 @@
+package metrics
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// Filter selects the metrics to expose by name and type
+type Filter struct {
+	// Prefix is the required prefix of the metric names
+	Prefix string
+	// Match is the glob pattern the metric names must match. '*' matches any sequence of characters
+	// (including dots and slashes) and '?' any single character
+	Match *regexp.Regexp
+	// Types is the set of accepted metric types (counter, gauge, histogram, meter, timer). Empty means all
+	Types map[string]bool
+}
+
+var validMetricTypes = map[string]bool{"counter": true, "gauge": true, "histogram": true, "meter": true, "timer": true}
+
+// ParseFilter builds a Filter from the query params prefix, match and type (a comma separated list)
+func ParseFilter(q url.Values) (Filter, error) {
+	f := Filter{Prefix: q.Get("prefix")}
+
+	if match := q.Get("match"); match != "" {
+		re, err := compileGlob(match)
+		if err != nil {
+			return f, err
+		}
+		f.Match = re
+	}
+
+	for _, types := range q["type"] {
+		for _, t := range strings.Split(types, ",") {
+			t = strings.ToLower(strings.TrimSpace(t))
+			if t == "" {
+				continue
+			}
+			if !validMetricTypes[t] {
+				return f, errors.New("unknown metric type: " + t)
+			}
+			if f.Types == nil {
+				f.Types = map[string]bool{}
+			}
+			f.Types[t] = true
+		}
+	}
+	return f, nil
+}
+
+// compileGlob compiles a glob pattern where '*' matches any sequence of characters and '?' any single
+// character
+func compileGlob(glob string) (*regexp.Regexp, error) {
+	pattern := regexp.QuoteMeta(glob)
+	pattern = strings.ReplaceAll(pattern, `\*`, ".*")
+	pattern = strings.ReplaceAll(pattern, `\?`, ".")
+	return regexp.Compile("^" + pattern + "$")
+}
+
+// IsEmpty returns true if the filter accepts every metric
+func (f Filter) IsEmpty() bool {
+	return f.Prefix == "" && f.Match == nil && len(f.Types) == 0
+}
+
+// AllowsName returns true if the name passes the name filters
+func (f Filter) AllowsName(name string) bool {
+	if !strings.HasPrefix(name, f.Prefix) {
+		return false
+	}
+	return f.Match == nil || f.Match.MatchString(name)
+}
+
+// Allows returns true if a metric with the given name and type (as returned by MetricType) passes the filter
+func (f Filter) Allows(name, metricType string) bool {
+	if len(f.Types) > 0 && !f.Types[metricType] {
+		return false
+	}
+	return f.AllowsName(name)
+}
+
+// MetricType returns the name of the type of the metric
+func MetricType(v interface{}) string {
+	switch v.(type) {
+	case metrics.Counter:
+		return "counter"
+	case metrics.Gauge, metrics.GaugeFloat64:
+		return "gauge"
+	case metrics.Histogram:
+		return "histogram"
+	case metrics.Meter:
+		return "meter"
+	case metrics.Timer:
+		return "timer"
+	}
+	return ""
+}
+
+// Filter returns a copy of the snapshot with only the metrics passing the filter
+func (s Stats) Filter(f Filter) Stats {
+	if f.IsEmpty() {
+		return s
+	}
+	res := Stats{
+		Time:        s.Time,
+		Counters:    map[string]int64{},
+		Gauges:      map[string]int64{},
+		FloatGauges: map[string]float64{},
+		Histograms:  map[string]HistogramData{},
+		Anomalies:   s.Anomalies,
+	}
+	for k, v := range s.Counters {
+		if f.Allows(k, "counter") {
+			res.Counters[k] = v
+		}
+	}
+	for k, v := range s.Gauges {
+		if f.Allows(k, "gauge") {
+			res.Gauges[k] = v
+		}
+	}
+	for k, v := range s.FloatGauges {
+		if f.Allows(k, "gauge") {
+			res.FloatGauges[k] = v
+		}
+	}
+	for k, v := range s.Histograms {
+		if f.Allows(k, "histogram") {
+			res.Histograms[k] = v
+		}
+	}
+	return res
+}
@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestParseFilter(t *testing.T) {
+	f, err := ParseFilter(url.Values{
+		"prefix": {"krakend.router."},
+		"match":  {"*.response./test/{var}.*"},
+		"type":   {"counter,histogram"},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, tc := range []struct {
+		name       string
+		metricType string
+		want       bool
+	}{
+		{"krakend.router.response./test/{var}.status.200.count", "counter", true},
+		{"krakend.router.response./test/{var}.time", "histogram", true},
+		{"krakend.router.response./test/{var}.time", "gauge", false},
+		{"krakend.router.response./other.time", "histogram", false},
+		{"krakend.proxy.response./test/{var}.time", "histogram", false},
+	} {
+		if have := f.Allows(tc.name, tc.metricType); have != tc.want {
+			t.Errorf("%s (%s): have %v, want %v", tc.name, tc.metricType, have, tc.want)
+		}
+	}
+
+	if _, err := ParseFilter(url.Values{"type": {"summary"}}); err == nil {
+		t.Error("unknown types should be rejected")
+	}
+	if f, _ := ParseFilter(url.Values{}); !f.IsEmpty() {
+		t.Error("the filter should be empty")
+	}
+}
+
+func TestStats_Filter(t *testing.T) {
+	s := NewStats()
+	s.Counters["a.count"] = 1
+	s.Counters["b.count"] = 2
+	s.Gauges["a.gauge"] = 3
+	s.FloatGauges["a.apdex"] = 0.5
+	s.Histograms["a.time"] = HistogramData{}
+
+	f, _ := ParseFilter(url.Values{"prefix": {"a."}, "type": {"counter,histogram"}})
+	res := s.Filter(f)
+	if len(res.Counters) != 1 || res.Counters["a.count"] != 1 {
+		t.Errorf("unexpected counters: %v", res.Counters)
+	}
+	if len(res.Gauges) != 0 || len(res.FloatGauges) != 0 {
+		t.Errorf("unexpected gauges: %v %v", res.Gauges, res.FloatGauges)
+	}
+	if _, ok := res.Histograms["a.time"]; !ok || len(res.Histograms) != 1 {
+		t.Errorf("unexpected histograms: %v", res.Histograms)
+	}
+}
+
+func TestMetricType(t *testing.T) {
+	for want, v := range map[string]interface{}{
+		"counter":   metrics.NewCounter(),
+		"gauge":     metrics.NewGaugeFloat64(),
+		"histogram": metrics.NewHistogram(metrics.NewUniformSample(10)),
+		"meter":     metrics.NewMeter(),
+		"timer":     metrics.NewTimer(),
+		"":          struct{}{},
+	} {
+		if have := MetricType(v); have != want {
+			t.Errorf("have %s, want %s", have, want)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package metrics
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+type namedMetric struct {
+	name   string
+	metric interface{}
+}
+
+// sortedMetrics returns the metrics of the registry passing the filter, sorted by name
+func sortedMetrics(r metrics.Registry, f Filter) []namedMetric {
+	res := []namedMetric{}
+	r.Each(func(name string, v interface{}) {
+		if f.Allows(name, MetricType(v)) {
+			res = append(res, namedMetric{name, v})
+		}
+	})
+	sort.Slice(res, func(i, j int) bool { return res[i].name < res[j].name })
+	return res
+}
+
+// PrometheusName converts a dotted metric name into a valid Prometheus metric name
+func PrometheusName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_' || c == ':' || (c >= '0' && c <= '9' && i > 0) {
+			continue
+		}
+		b[i] = '_'
+	}
+	return string(b)
+}
+
+// metricFamily groups the metrics sharing a Prometheus name. Several dotted names collide when they
+// only differ in the chars not allowed by Prometheus (ex: /foo-bar and /foo_bar), so every metric of a
+// family with more than one member carries its dotted name in the "name" label
+type metricFamily struct {
+	name    string
+	metrics []namedMetric
+}
+
+// prometheusFamilies returns the metrics of the registry passing the filter grouped by Prometheus name,
+// sorted by name. The metrics of a different kind than the first one of their family are discarded,
+// since a family has a single type
+func prometheusFamilies(r metrics.Registry, f Filter, kind func(interface{}) string) []*metricFamily {
+	res := []*metricFamily{}
+	families := map[string]*metricFamily{}
+	for _, m := range sortedMetrics(r, f) {
+		name := PrometheusName(m.name)
+		family, ok := families[name]
+		if !ok {
+			family = &metricFamily{name: name}
+			families[name] = family
+			res = append(res, family)
+		} else if kind(family.metrics[0].metric) != kind(m.metric) {
+			continue
+		}
+		family.metrics = append(family.metrics, m)
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].name < res[j].name })
+	return res
+}
+
+// labels returns the label set of the i-th metric of the family with the extra labels (pairs of name
+// and value), or an empty string if there are no labels
+func (f *metricFamily) labels(i int, extra ...string) string {
+	pairs := []string{}
+	if len(f.metrics) > 1 {
+		pairs = append(pairs, `name="`+labelValueEscaper.Replace(f.metrics[i].name)+`"`)
+	}
+	for j := 0; j+1 < len(extra); j += 2 {
+		pairs = append(pairs, extra[j]+`="`+labelValueEscaper.Replace(extra[j+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// WritePrometheus writes the metrics of the registry passing the filter using the Prometheus text
+// exposition format (version 0.0.4). Histograms and timers are exposed as summaries. The _sum and the
+// _count of the summaries are the totals since the creation of the histograms, and they are omitted for
+// the histograms not keeping them, since the histograms are cleared by every snapshot
+func WritePrometheus(w io.Writer, r metrics.Registry, f Filter) error {
+	bw := bufio.NewWriter(w)
+	for _, family := range prometheusFamilies(r, f, MetricType) {
+		name := family.name
+		switch family.metrics[0].metric.(type) {
+		case metrics.Counter, metrics.Meter:
+			fmt.Fprintf(bw, "# TYPE %s counter\n", name)
+		case metrics.Gauge, metrics.GaugeFloat64:
+			fmt.Fprintf(bw, "# TYPE %s gauge\n", name)
+		case metrics.Histogram, metrics.Timer:
+			fmt.Fprintf(bw, "# TYPE %s summary\n", name)
+		}
+		for i, m := range family.metrics {
+			switch metric := m.metric.(type) {
+			case metrics.Counter:
+				fmt.Fprintf(bw, "%s%s %d\n", name, family.labels(i), metric.Count())
+			case metrics.Gauge:
+				fmt.Fprintf(bw, "%s%s %d\n", name, family.labels(i), metric.Value())
+			case metrics.GaugeFloat64:
+				fmt.Fprintf(bw, "%s%s %s\n", name, family.labels(i), formatFloat(metric.Value()))
+			case metrics.Histogram:
+				h := metric.Snapshot()
+				writeSummary(bw, family, i, h.Percentiles(percentiles))
+				if c, ok := metric.(*cumulativeHistogram); ok {
+					count, sum := c.Totals()
+					writeSummaryTotals(bw, family, i, sum, count)
+				}
+			case metrics.Timer:
+				t := metric.Snapshot()
+				writeSummary(bw, family, i, t.Percentiles(percentiles))
+				writeSummaryTotals(bw, family, i, t.Sum(), t.Count())
+			case metrics.Meter:
+				fmt.Fprintf(bw, "%s%s %d\n", name, family.labels(i), metric.Snapshot().Count())
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+func writeSummary(w io.Writer, family *metricFamily, i int, ps []float64) {
+	for j, p := range percentiles {
+		fmt.Fprintf(w, "%s%s %s\n", family.name, family.labels(i, "quantile", formatFloat(p)), formatFloat(ps[j]))
+	}
+}
+
+func writeSummaryTotals(w io.Writer, family *metricFamily, i int, sum, count int64) {
+	fmt.Fprintf(w, "%s_sum%s %d\n%s_count%s %d\n", family.name, family.labels(i), sum, family.name, family.labels(i), count)
+}
+
+// WriteOpenMetrics writes the metrics of the registry passing the filter using the OpenMetrics text
+// format (version 1.0.0). The histograms with buckets (the durations of the endpoints and the proxy
+// middlewares, when the slow requests are enabled) are exposed as histograms carrying the slow request
+// exemplars, indexed by metric name. The rest of histograms and the timers are exposed as summaries, as
+// in WritePrometheus
+func WriteOpenMetrics(w io.Writer, r metrics.Registry, f Filter, exemplars map[string][]Exemplar) error {
+	bw := bufio.NewWriter(w)
+	for _, family := range prometheusFamilies(r, f, openMetricsType) {
+		name := family.name
+		kind := openMetricsType(family.metrics[0].metric)
+		if kind == "counter" {
+			name = strings.TrimSuffix(name, "_total")
+		}
+		fmt.Fprintf(bw, "# TYPE %s %s\n", name, kind)
+		for i, m := range family.metrics {
+			labels := family.labels(i)
+			switch metric := m.metric.(type) {
+			case metrics.Counter:
+				fmt.Fprintf(bw, "%s_total%s %d\n", name, labels, metric.Count())
+			case metrics.Gauge:
+				fmt.Fprintf(bw, "%s%s %d\n", name, labels, metric.Value())
+			case metrics.GaugeFloat64:
+				fmt.Fprintf(bw, "%s%s %s\n", name, labels, formatFloat(metric.Value()))
+			case metrics.Histogram:
+				c, ok := metric.(*cumulativeHistogram)
+				if kind == "histogram" {
+					writeOpenMetricsHistogram(bw, family, i, c, exemplars[m.name])
+					continue
+				}
+				writeSummary(bw, family, i, metric.Snapshot().Percentiles(percentiles))
+				if ok {
+					count, sum := c.Totals()
+					writeSummaryTotals(bw, family, i, sum, count)
+				}
+			case metrics.Timer:
+				t := metric.Snapshot()
+				writeSummary(bw, family, i, t.Percentiles(percentiles))
+				writeSummaryTotals(bw, family, i, t.Sum(), t.Count())
+			case metrics.Meter:
+				fmt.Fprintf(bw, "%s_total%s %d\n", name, labels, metric.Snapshot().Count())
+			}
+		}
+	}
+	io.WriteString(bw, "# EOF\n")
+	return bw.Flush()
+}
+
+// openMetricsType returns the OpenMetrics type of the metric
+func openMetricsType(v interface{}) string {
+	switch metric := v.(type) {
+	case metrics.Counter, metrics.Meter:
+		return "counter"
+	case metrics.Gauge, metrics.GaugeFloat64:
+		return "gauge"
+	case metrics.Histogram:
+		if c, ok := metric.(*cumulativeHistogram); ok && len(c.bounds) > 0 {
+			return "histogram"
+		}
+		return "summary"
+	case metrics.Timer:
+		return "summary"
+	}
+	return "unknown"
+}
+
+// writeOpenMetricsHistogram writes the cumulative counts of the buckets of the histogram since its
+// creation. Every bucket gets the most recent exemplar falling into it
+func writeOpenMetricsHistogram(w io.Writer, family *metricFamily, i int, h *cumulativeHistogram, exemplars []Exemplar) {
+	bounds, counts := h.Buckets()
+	count, sum := h.Totals()
+	lower := int64(math.MinInt64)
+	for j := 0; j <= len(bounds); j++ {
+		le, upper, n := "+Inf", int64(math.MaxInt64), count
+		if j < len(bounds) {
+			le, upper, n = strconv.FormatInt(bounds[j], 10), bounds[j], counts[j]
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d", family.name, family.labels(i, "le", le), n)
+		for k := len(exemplars) - 1; k >= 0; k-- {
+			if v := exemplars[k].Value; v > lower && v <= upper {
+				writeExemplar(w, exemplars[k])
+				break
+			}
+		}
+		io.WriteString(w, "\n")
+		lower = upper
+	}
+	fmt.Fprintf(w, "%s_sum%s %d\n%s_count%s %d\n", family.name, family.labels(i), sum, family.name, family.labels(i), count)
+}
+
+// maxExemplarRequestID keeps the label set of the exemplars under the 128 characters allowed by
+// OpenMetrics
+const maxExemplarRequestID = 100
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func writeExemplar(w io.Writer, e Exemplar) {
+	labels := ""
+	if id := []rune(e.RequestID); len(id) > 0 {
+		if len(id) > maxExemplarRequestID {
+			id = id[:maxExemplarRequestID]
+		}
+		labels = `request_id="` + labelValueEscaper.Replace(string(id)) + `"`
+	}
+	ts := float64(e.Time) / float64(time.Second)
+	fmt.Fprintf(w, " # {%s} %d %s", labels, e.Value, strconv.FormatFloat(ts, 'f', 3, 64))
+}
+
+// WriteCSV writes the metrics of the registry passing the filter as CSV records with the columns name,
+// type, field and value
+func WriteCSV(w io.Writer, r metrics.Registry, f Filter) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"name", "type", "field", "value"}); err != nil {
+		return err
+	}
+	for _, m := range sortedMetrics(r, f) {
+		t := MetricType(m.metric)
+		record := func(field, value string) {
+			cw.Write([]string{m.name, t, field, value})
+		}
+		switch metric := m.metric.(type) {
+		case metrics.Counter:
+			record("count", strconv.FormatInt(metric.Count(), 10))
+		case metrics.Gauge:
+			record("value", strconv.FormatInt(metric.Value(), 10))
+		case metrics.GaugeFloat64:
+			record("value", formatFloat(metric.Value()))
+		case metrics.Histogram:
+			h := metric.Snapshot()
+			writeCSVSample(record, h.Count(), h.Min(), h.Max(), h.Mean(), h.StdDev(), h.Percentiles(percentiles))
+		case metrics.Timer:
+			s := metric.Snapshot()
+			writeCSVSample(record, s.Count(), s.Min(), s.Max(), s.Mean(), s.StdDev(), s.Percentiles(percentiles))
+			writeCSVRates(record, s.Rate1(), s.Rate5(), s.Rate15(), s.RateMean())
+		case metrics.Meter:
+			s := metric.Snapshot()
+			record("count", strconv.FormatInt(s.Count(), 10))
+			writeCSVRates(record, s.Rate1(), s.Rate5(), s.Rate15(), s.RateMean())
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeCSVSample(record func(string, string), count, min, max int64, mean, stddev float64, ps []float64) {
+	record("count", strconv.FormatInt(count, 10))
+	record("min", strconv.FormatInt(min, 10))
+	record("max", strconv.FormatInt(max, 10))
+	record("mean", formatFloat(mean))
+	record("stddev", formatFloat(stddev))
+	for i, p := range percentiles {
+		record("p"+formatFloat(p*100), formatFloat(ps[i]))
+	}
+}
+
+func writeCSVRates(record func(string, string), rate1, rate5, rate15, rateMean float64) {
+	record("rate1", formatFloat(rate1))
+	record("rate5", formatFloat(rate5))
+	record("rate15", formatFloat(rate15))
+	record("rate_mean", formatFloat(rateMean))
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,211 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("router.response./test/{var}.status.200.count", r).Inc(3)
+	metrics.GetOrRegisterGauge("router.connected-gauge", r).Update(7)
+	h := getOrRegisterHistogram("router.response./test/{var}.size", r)
+	h.Update(10)
+	h.Update(20)
+
+	buf := new(bytes.Buffer)
+	if err := WritePrometheus(buf, r, Filter{}); err != nil {
+		t.Error(err)
+		return
+	}
+	for _, line := range []string{
+		"# TYPE router_response__test__var__status_200_count counter",
+		"router_response__test__var__status_200_count 3",
+		"# TYPE router_connected_gauge gauge",
+		"router_connected_gauge 7",
+		"# TYPE router_response__test__var__size summary",
+		`router_response__test__var__size{quantile="0.99"} 20`,
+		"router_response__test__var__size_sum 30",
+		"router_response__test__var__size_count 2",
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("line not found: %s\n%s", line, buf.String())
+		}
+	}
+
+	if PrometheusName("9.a-b") != "__a_b" {
+		t.Errorf("unexpected name: %s", PrometheusName("9.a-b"))
+	}
+}
+
+func TestWritePrometheus_cumulative(t *testing.T) {
+	r := metrics.NewRegistry()
+	h := getOrRegisterHistogram("router.response./foo.time", r)
+	h.Update(10)
+	h.Clear()
+	h.Update(20)
+	metrics.GetOrRegisterHistogram("service.debug.GCStats.Pause", r, metrics.NewUniformSample(10)).Update(5)
+
+	buf := new(bytes.Buffer)
+	if err := WritePrometheus(buf, r, Filter{}); err != nil {
+		t.Error(err)
+		return
+	}
+	for _, line := range []string{
+		`router_response__foo_time{quantile="0.5"} 20`,
+		"router_response__foo_time_sum 30",
+		"router_response__foo_time_count 2",
+		`service_debug_GCStats_Pause{quantile="0.5"} 5`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("line not found: %s\n%s", line, buf.String())
+		}
+	}
+	if strings.Contains(buf.String(), "service_debug_GCStats_Pause_count") {
+		t.Errorf("the totals of the cleared histograms should not be exposed:\n%s", buf.String())
+	}
+}
+
+func TestWritePrometheus_collisions(t *testing.T) {
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("router.response./foo-bar.count", r).Inc(1)
+	metrics.GetOrRegisterCounter("router.response./foo_bar.count", r).Inc(2)
+	metrics.GetOrRegisterGauge("router.response./foo~bar.count", r).Update(3)
+	getOrRegisterHistogram("router.response./a-b.time", r).Update(5)
+	getOrRegisterHistogram("router.response./a_b.time", r).Update(7)
+
+	buf := new(bytes.Buffer)
+	if err := WritePrometheus(buf, r, Filter{}); err != nil {
+		t.Error(err)
+		return
+	}
+	for _, line := range []string{
+		"# TYPE router_response__foo_bar_count counter",
+		`router_response__foo_bar_count{name="router.response./foo-bar.count"} 1`,
+		`router_response__foo_bar_count{name="router.response./foo_bar.count"} 2`,
+		`router_response__a_b_time{name="router.response./a-b.time",quantile="0.5"} 5`,
+		`router_response__a_b_time_count{name="router.response./a_b.time"} 1`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("line not found: %s\n%s", line, buf.String())
+		}
+	}
+	if n := strings.Count(buf.String(), "# TYPE router_response__foo_bar_count"); n != 1 {
+		t.Errorf("unexpected number of families: %d\n%s", n, buf.String())
+	}
+	if strings.Contains(buf.String(), "foo~bar") {
+		t.Errorf("the metrics of a different type should be discarded:\n%s", buf.String())
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("a.count", r).Inc(3)
+	metrics.GetOrRegisterHistogram("a.time", r, metrics.NewUniformSample(10)).Update(5)
+	metrics.GetOrRegisterGauge("b.gauge", r).Update(1)
+
+	buf := new(bytes.Buffer)
+	if err := WriteCSV(buf, r, Filter{Prefix: "a."}); err != nil {
+		t.Error(err)
+		return
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if lines[0] != "name,type,field,value" || lines[1] != "a.count,counter,count,3" {
+		t.Errorf("unexpected output: %s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "a.time,histogram,p99,5\n") {
+		t.Errorf("percentile not found: %s", buf.String())
+	}
+	if strings.Contains(buf.String(), "b.gauge") {
+		t.Errorf("the filter should be applied: %s", buf.String())
+	}
+}
+
+func TestWriteOpenMetrics(t *testing.T) {
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("router.requests_total", r).Inc(3)
+	metrics.GetOrRegisterGauge("router.connected-gauge", r).Update(7)
+	h := getOrRegisterBucketedHistogram("router.response./foo.time", r, []int64{50, 100, 200})
+	for _, v := range []int64{40, 100, 100, 150, 500} {
+		h.Update(v)
+	}
+	// the observations before the last snapshot are still counted
+	h.Clear()
+	getOrRegisterBucketedHistogram("router.response./bar.time", r, []int64{50, 100, 200})
+	getOrRegisterHistogram("router.response./foo.size", r).Update(10)
+
+	exemplars := map[string][]Exemplar{
+		"router.response./foo.time": {
+			{RequestID: "old", Value: 90, Time: 1e9},
+			{RequestID: `a"b`, Value: 100, Time: 2e9},
+			{Value: 500, Time: 3e9},
+		},
+	}
+	buf := new(bytes.Buffer)
+	if err := WriteOpenMetrics(buf, r, Filter{}, exemplars); err != nil {
+		t.Fatal(err)
+	}
+	want := `# TYPE router_connected_gauge gauge
+router_connected_gauge 7
+# TYPE router_requests counter
+router_requests_total 3
+# TYPE router_response__bar_time histogram
+router_response__bar_time_bucket{le="50"} 0
+router_response__bar_time_bucket{le="100"} 0
+router_response__bar_time_bucket{le="200"} 0
+router_response__bar_time_bucket{le="+Inf"} 0
+router_response__bar_time_sum 0
+router_response__bar_time_count 0
+# TYPE router_response__foo_size summary
+router_response__foo_size{quantile="0.1"} 10
+router_response__foo_size{quantile="0.25"} 10
+router_response__foo_size{quantile="0.5"} 10
+router_response__foo_size{quantile="0.75"} 10
+router_response__foo_size{quantile="0.9"} 10
+router_response__foo_size{quantile="0.95"} 10
+router_response__foo_size{quantile="0.99"} 10
+router_response__foo_size_sum 10
+router_response__foo_size_count 1
+# TYPE router_response__foo_time histogram
+router_response__foo_time_bucket{le="50"} 1
+router_response__foo_time_bucket{le="100"} 3 # {request_id="a\"b"} 100 2.000
+router_response__foo_time_bucket{le="200"} 4
+router_response__foo_time_bucket{le="+Inf"} 5 # {} 500 3.000
+router_response__foo_time_sum 890
+router_response__foo_time_count 5
+# EOF
+`
+	if buf.String() != want {
+		t.Errorf("unexpected output:\n%s", buf.String())
+	}
+}
+
+func TestWriteOpenMetrics_collisions(t *testing.T) {
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("router.response./a-b.count", r).Inc(1)
+	metrics.GetOrRegisterCounter("router.response./a_b.count", r).Inc(2)
+	getOrRegisterBucketedHistogram("router.response./c-d.time", r, []int64{10}).Update(5)
+	getOrRegisterBucketedHistogram("router.response./c_d.time", r, []int64{10}).Update(50)
+
+	buf := new(bytes.Buffer)
+	if err := WriteOpenMetrics(buf, r, Filter{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`router_response__a_b_count_total{name="router.response./a-b.count"} 1`,
+		`router_response__a_b_count_total{name="router.response./a_b.count"} 2`,
+		`router_response__c_d_time_bucket{name="router.response./c-d.time",le="10"} 1`,
+		`router_response__c_d_time_bucket{name="router.response./c_d.time",le="10"} 0`,
+		`router_response__c_d_time_count{name="router.response./c_d.time"} 1`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("line not found: %s\n%s", line, buf.String())
+		}
+	}
+	if n := strings.Count(buf.String(), "# TYPE "); n != 2 {
+		t.Errorf("unexpected number of families: %d\n%s", n, buf.String())
+	}
+}
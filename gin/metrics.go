@@ -3,7 +3,9 @@
 package gin
 
 import (
+	"bufio"
 	"context"
+	"net"
 	"net/http"
 	"strconv"
 	"time"
@@ -27,6 +29,16 @@ func New(ctx context.Context, e config.ExtraConfig, l logging.Logger) *Metrics {
 	return &metricsCollector
 }
 
+// NewFromServiceConfig creates a new metrics producer with support for the gin router, registering the
+// gauges describing the service config
+func NewFromServiceConfig(ctx context.Context, cfg config.ServiceConfig, l logging.Logger) *Metrics {
+	metricsCollector := Metrics{metrics.NewFromServiceConfig(ctx, cfg, l)}
+	if metricsCollector.Config != nil && !metricsCollector.Config.EndpointDisabled {
+		metricsCollector.RunEndpoint(ctx, metricsCollector.NewEngine(), l)
+	}
+	return &metricsCollector
+}
+
 // Metrics is the component that manages all the metrics for the gin-based gateways
 type Metrics struct {
 	*metrics.Metrics
@@ -40,8 +52,22 @@ func (m *Metrics) RunEndpoint(ctx context.Context, e *gin.Engine, l logging.Logg
 		Handler:           e,
 		ReadHeaderTimeout: 3 * time.Second,
 	}
+	if m.Config.TLS != nil {
+		tlsCfg, err := metrics.NewServerTLSConfig(m.Config.TLS)
+		if err != nil {
+			l.Error(logPrefix, "Unable to start the metrics endpoint with TLS:", err.Error())
+			return
+		}
+		server.TLSConfig = tlsCfg
+	}
 	go func() {
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if server.TLSConfig != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			l.Error(logPrefix, err.Error())
 		}
 	}()
@@ -57,22 +83,50 @@ func (m *Metrics) RunEndpoint(ctx context.Context, e *gin.Engine, l logging.Logg
 	l.Debug(logPrefix, "The endpoint /__stats is now available on", m.Config.ListenAddr)
 }
 
-// NewEngine returns a *gin.Engine with some defaults and the stats endpoint (no logger)
+// NewEngine returns a *gin.Engine with some defaults and the stats endpoint (no logger). Every route is
+// protected by the access control rules of the stats server, if any
 func (m *Metrics) NewEngine() *gin.Engine {
 	gin.SetMode(gin.ReleaseMode)
 	engine := gin.New()
 	engine.Use(gin.Recovery())
+	if auth := m.Authenticator(); auth != nil {
+		engine.Use(NewAuthMiddleware(auth))
+	}
 	engine.RedirectTrailingSlash = true
 	engine.RedirectFixedPath = true
 	engine.HandleMethodNotAllowed = true
 
 	engine.GET("/__stats", m.NewExpHandler())
+	engine.GET("/__stats/stream", gin.WrapH(mux.NewStreamHandler(m.Metrics)))
+	engine.GET("/__stats/ui", gin.WrapH(mux.NewUIHandler()))
+	engine.GET("/__stats/top", gin.WrapH(mux.NewTopHandler(m.Router.HeavyHitters())))
+	engine.GET("/__health", gin.WrapH(mux.NewHealthHandler()))
+	engine.GET("/__ready", gin.WrapH(mux.NewReadyHandler(m.Metrics)))
+	engine.GET("/__version", gin.WrapH(mux.NewVersionHandler()))
+	if m.Config != nil && m.Config.Pprof != nil {
+		pprofHandler := gin.WrapH(mux.NewPprofHandler(m.Config.Pprof.MaxDuration))
+		engine.GET("/debug/pprof/*profile", pprofHandler)
+		engine.POST("/debug/pprof/symbol", pprofHandler)
+	}
 	return engine
 }
 
-// NewExpHandler creates an http.Handler ready to expose all the collected metrics as a JSON
+// NewAuthMiddleware returns a middleware rejecting the requests not passing the access control rules
+func NewAuthMiddleware(auth *metrics.Authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if status := auth.Authorize(c.Request); status != http.StatusOK {
+			auth.Reject(c.Writer, status)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// NewExpHandler creates an http.Handler ready to expose all the collected metrics as a JSON. Check
+// mux.NewStatsHandler for the supported filters and formats
 func (m *Metrics) NewExpHandler() gin.HandlerFunc {
-	return gin.WrapH(mux.NewExpHandler(m.Registry))
+	return gin.WrapH(mux.NewStatsHandler(m.Metrics))
 }
 
 // NewHTTPHandlerFactory wraps a handler factory adding some simple instrumentation to the generated handlers
@@ -89,28 +143,114 @@ func NewHTTPHandlerFactory(rm *metrics.RouterMetrics, hf krakendgin.HandlerFacto
 		next := hf(cfg, p)
 		rm.RegisterResponseWriterMetrics(cfg.Endpoint)
 		return func(c *gin.Context) {
-			rw := &ginResponseWriter{c.Writer, cfg.Endpoint, time.Now(), rm}
+			rw := &ginResponseWriter{
+				ResponseWriter: c.Writer,
+				name:           cfg.Endpoint,
+				consumer:       rm.Consumer(c.Request),
+				begin:          rm.Now(),
+				rm:             rm,
+			}
 			c.Writer = rw
 			rm.Connection(c.Request.TLS)
+			rm.HeavyHitters().Record(c.Request)
+			defer func() {
+				v := recover()
+				if v != nil && !rw.Written() {
+					// the router middleware recovers the panic responding with a 500
+					rw.ResponseWriter.WriteHeader(http.StatusInternalServerError)
+				}
+				rw.end()
+				if !rw.hijacked {
+					rm.SlowResponse(cfg.Endpoint, c.Request, rw.Status(), rm.Now().Sub(rw.begin))
+				}
+				rm.Disconnection()
+				if v != nil {
+					panic(v)
+				}
+			}()
 
 			next(c)
-
-			rw.end()
-			rm.Disconnection()
 		}
 	}
 }
 
 type ginResponseWriter struct {
 	gin.ResponseWriter
-	name  string
-	begin time.Time
-	rm    *metrics.RouterMetrics
+	name      string
+	consumer  string
+	begin     time.Time
+	firstByte time.Time
+	flushes   int
+	hijacked  bool
+	rm        *metrics.RouterMetrics
+}
+
+// Hijack implements the http.Hijacker interface
+func (w *ginResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, buf, err := w.ResponseWriter.Hijack()
+	if err != nil {
+		return conn, buf, err
+	}
+	w.hijacked = true
+	conn = w.rm.Hijacked(w.name, conn)
+	// the buffered reader and writer must go through the instrumented connection. The buffers holding
+	// data are kept, since resetting them would lose it
+	if buf != nil && buf.Reader.Buffered() == 0 {
+		buf.Reader.Reset(conn)
+	}
+	if buf != nil && buf.Writer.Buffered() == 0 {
+		buf.Writer.Reset(conn)
+	}
+	return conn, buf, nil
+}
+
+// WriteHeader implements the http.ResponseWriter interface
+func (w *ginResponseWriter) WriteHeader(code int) {
+	w.markFirstByte()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// WriteHeaderNow implements the gin.ResponseWriter interface
+func (w *ginResponseWriter) WriteHeaderNow() {
+	w.markFirstByte()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+// Write implements the http.ResponseWriter interface
+func (w *ginResponseWriter) Write(data []byte) (int, error) {
+	w.markFirstByte()
+	return w.ResponseWriter.Write(data)
+}
+
+// WriteString implements the gin.ResponseWriter interface
+func (w *ginResponseWriter) WriteString(s string) (int, error) {
+	w.markFirstByte()
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Flush implements the http.Flusher interface
+func (w *ginResponseWriter) Flush() {
+	w.markFirstByte()
+	w.flushes++
+	w.ResponseWriter.Flush()
+}
+
+func (w *ginResponseWriter) markFirstByte() {
+	if w.firstByte.IsZero() {
+		w.firstByte = w.rm.Now()
+	}
 }
 
 func (w *ginResponseWriter) end() {
-	duration := time.Since(w.begin)
+	if w.hijacked {
+		return
+	}
+	now := w.rm.Now()
+	// the size is -1 when nothing has been written
+	size := int64(max(w.Size(), 0))
 	w.rm.Counter("response", w.name, "status", strconv.Itoa(w.Status()), "count").Inc(1)
-	w.rm.Histogram("response", w.name, "size").Update(int64(w.Size()))
-	w.rm.Histogram("response", w.name, "time").Update(int64(duration))
+	w.rm.Histogram("response", w.name, "size").Update(size)
+	w.rm.Histogram("response", w.name, "time").Update(int64(now.Sub(w.begin)))
+	w.rm.ResponseStreamed(w.name, w.begin, w.firstByte, now, w.flushes, size)
+	w.rm.ConsumerResponse(w.consumer, w.Status(), size, now.Sub(w.begin))
 }
@@ -1,14 +1,18 @@
 package gin
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"math/rand"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -18,6 +22,7 @@ import (
 	"github.com/luraproject/lura/v2/logging"
 	"github.com/luraproject/lura/v2/proxy"
 	krakendgin "github.com/luraproject/lura/v2/router/gin"
+	gometrics "github.com/rcrowley/go-metrics"
 )
 
 func TestDisabledRouterMetrics(t *testing.T) {
@@ -109,6 +114,327 @@ func TestNew(t *testing.T) {
 	}
 }
 
+func TestNewHTTPHandlerFactory_streaming(t *testing.T) {
+	registry := gometrics.NewRegistry()
+	rm := metrics.NewRouterMetrics(&registry)
+
+	hf := NewHTTPHandlerFactory(rm, func(_ *config.EndpointConfig, _ proxy.Proxy) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			time.Sleep(time.Millisecond)
+			c.Status(200)
+			for i := 0; i < 3; i++ {
+				c.Writer.WriteString("chunk")
+				c.Writer.Flush()
+				time.Sleep(time.Millisecond)
+			}
+		}
+	})
+
+	engine := gin.New()
+	engine.GET("/stream", hf(&config.EndpointConfig{Endpoint: "/stream"}, proxy.NoopProxy))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/stream", http.NoBody)
+	engine.ServeHTTP(w, req)
+
+	if w.Body.String() != "chunkchunkchunk" {
+		t.Errorf("unexpected body: %s", w.Body.String())
+	}
+
+	ttfb := registry.Get("router.response./stream.ttfb").(gometrics.Histogram)
+	if ttfb.Count() != 1 || ttfb.Max() < int64(time.Millisecond) {
+		t.Errorf("unexpected ttfb. count: %d, max: %d", ttfb.Count(), ttfb.Max())
+	}
+	if h := registry.Get("router.response./stream.stream.flushes").(gometrics.Histogram); h.Max() != 3 {
+		t.Errorf("unexpected number of flushes: %d", h.Max())
+	}
+	if h := registry.Get("router.response./stream.stream.size").(gometrics.Histogram); h.Max() != 15 {
+		t.Errorf("unexpected streamed size: %d", h.Max())
+	}
+	if h := registry.Get("router.response./stream.stream.time").(gometrics.Histogram); h.Max() < int64(2*time.Millisecond) {
+		t.Errorf("unexpected streaming time: %d", h.Max())
+	}
+}
+
+func TestNewHTTPHandlerFactory_hijacked(t *testing.T) {
+	registry := gometrics.NewRegistry()
+	rm := metrics.NewRouterMetrics(&registry)
+
+	done := make(chan struct{})
+	hf := NewHTTPHandlerFactory(rm, func(_ *config.EndpointConfig, _ proxy.Proxy) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			defer close(done)
+			conn, buf, err := c.Writer.Hijack()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer conn.Close()
+			buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n")
+			buf.Flush()
+			line, err := buf.ReadString('\n')
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			buf.WriteString("pong:" + line)
+			buf.Flush()
+		}
+	})
+
+	engine := gin.New()
+	engine.GET("/ws", hf(&config.EndpointConfig{Endpoint: "/ws"}, proxy.NoopProxy))
+	ts := httptest.NewServer(engine)
+	defer ts.Close()
+
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	fmt.Fprintf(conn, "GET /ws HTTP/1.1\r\nHost: %s\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n", ts.Listener.Addr())
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	conn.Write([]byte("ping\n"))
+	if line, _ := r.ReadString('\n'); line != "pong:ping\n" {
+		t.Errorf("unexpected answer: %q", line)
+	}
+	<-done
+
+	if v := registry.Get("router.hijacked./ws.count").(gometrics.Counter).Count(); v != 1 {
+		t.Errorf("unexpected number of upgrades: %d", v)
+	}
+	if h := registry.Get("router.hijacked./ws.in").(gometrics.Histogram); h.Count() != 1 || h.Max() != 5 {
+		t.Errorf("unexpected bytes in. count: %d, max: %d", h.Count(), h.Max())
+	}
+	if h := registry.Get("router.hijacked./ws.out").(gometrics.Histogram); h.Count() != 1 || h.Max() != 82 {
+		t.Errorf("unexpected bytes out. count: %d, max: %d", h.Count(), h.Max())
+	}
+}
+
+func TestNewHTTPHandlerFactory_consumers(t *testing.T) {
+	registry := gometrics.NewRegistry()
+	rm := metrics.NewRouterMetrics(&registry)
+	rm.EnableConsumerMetrics(&metrics.ConsumerConfig{QueryString: "key", MaxConsumers: 10})
+
+	hf := NewHTTPHandlerFactory(rm, func(_ *config.EndpointConfig, _ proxy.Proxy) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			if c.Query("fail") != "" {
+				c.String(http.StatusForbidden, "nope")
+				return
+			}
+			c.String(http.StatusOK, "hello")
+		}
+	})
+
+	engine := gin.New()
+	engine.GET("/consumer", hf(&config.EndpointConfig{Endpoint: "/consumer"}, proxy.NoopProxy))
+
+	for _, target := range []string{"/consumer?key=acme", "/consumer?key=acme&fail=1"} {
+		req, _ := http.NewRequest("GET", target, http.NoBody)
+		engine.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	for name, want := range map[string]int64{
+		"router.consumer.acme.requests": 2,
+		"router.consumer.acme.errors":   1,
+		"router.consumer.acme.bytes":    9,
+	} {
+		if v := registry.Get(name).(gometrics.Counter).Count(); v != want {
+			t.Errorf("%s: want %d, have %d", name, want, v)
+		}
+	}
+	if h := registry.Get("router.consumer.acme.time").(gometrics.Histogram); h.Count() != 2 {
+		t.Errorf("unexpected number of response times: %d", h.Count())
+	}
+}
+
+func TestNewHTTPHandlerFactory_slowRequests(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	buf := new(bytes.Buffer)
+	l, _ := logging.NewLogger("DEBUG", buf, "")
+	metric := New(ctx, map[string]interface{}{metrics.Namespace: map[string]interface{}{
+		"endpoint_disabled": true,
+		"slow_requests": map[string]interface{}{
+			"thresholds":        map[string]interface{}{"router": "5ms"},
+			"request_id_header": "X-Trace",
+		},
+	}}, l)
+
+	hf := metric.NewHTTPHandlerFactory(func(_ *config.EndpointConfig, _ proxy.Proxy) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			time.Sleep(10 * time.Millisecond)
+			c.Status(http.StatusNoContent)
+		}
+	})
+	engine := gin.New()
+	engine.GET("/slow", hf(&config.EndpointConfig{Endpoint: "/slow"}, proxy.NoopProxy))
+
+	req, _ := http.NewRequest("GET", "/slow", http.NoBody)
+	req.Header.Set("X-Trace", "abc")
+	engine.ServeHTTP(httptest.NewRecorder(), req)
+
+	if !strings.Contains(buf.String(), "layer=router name=/slow path=/slow") || !strings.Contains(buf.String(), "status=204 request_id=abc") {
+		t.Errorf("slow request not logged: %s", buf.String())
+	}
+	if e := metric.Exemplars()["krakend.router.response./slow.time"]; len(e) != 1 || e[0].RequestID != "abc" {
+		t.Errorf("unexpected exemplars: %+v", e)
+	}
+}
+
+func TestNewEngine_auth(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	buf := bytes.NewBuffer(make([]byte, 1024))
+	l, _ := logging.NewLogger("DEBUG", buf, "")
+	cfg := map[string]interface{}{metrics.Namespace: map[string]interface{}{
+		"endpoint_disabled": true,
+		"auth": map[string]interface{}{
+			"allowed_cidrs": []interface{}{"127.0.0.0/8"},
+		},
+	}}
+	engine := New(ctx, cfg, l).NewEngine()
+
+	for remoteAddr, want := range map[string]int{
+		"127.0.0.1:1234": http.StatusOK,
+		"10.0.0.1:1234":  http.StatusForbidden,
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/__stats", http.NoBody)
+		req.RemoteAddr = remoteAddr
+		engine.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Errorf("%s: unexpected status code. have: %d, want: %d", remoteAddr, w.Code, want)
+		}
+	}
+
+	cfg = map[string]interface{}{metrics.Namespace: map[string]interface{}{
+		"endpoint_disabled": true,
+		"auth": map[string]interface{}{
+			"tokens_file": "/unknown/file",
+		},
+	}}
+	engine = New(ctx, cfg, l).NewEngine()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/__stats", http.NoBody)
+	req.RemoteAddr = "127.0.0.1:1234"
+	engine.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("an invalid auth config should deny every request. status: %d", w.Code)
+	}
+}
+
+func TestNewEngine_health(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	buf := bytes.NewBuffer(make([]byte, 1024))
+	l, _ := logging.NewLogger("DEBUG", buf, "")
+	cfg := map[string]interface{}{metrics.Namespace: map[string]interface{}{
+		"collection_time":   "100ms",
+		"endpoint_disabled": true,
+	}}
+	engine := New(ctx, cfg, l).NewEngine()
+
+	for path, want := range map[string]int{
+		"/__health":   http.StatusOK,
+		"/__ready":    http.StatusServiceUnavailable,
+		"/__version":  http.StatusOK,
+		"/__stats/ui": http.StatusOK,
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, http.NoBody)
+		engine.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Errorf("%s: unexpected status code. have: %d, want: %d", path, w.Code, want)
+		}
+	}
+
+	time.Sleep(250 * time.Millisecond)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/__ready", http.NoBody)
+	engine.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("unexpected readiness status code: %d", w.Code)
+	}
+}
+
+func TestNewEngine_top(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l, _ := logging.NewLogger("DEBUG", new(bytes.Buffer), "")
+	metric := New(ctx, map[string]interface{}{metrics.Namespace: map[string]interface{}{
+		"endpoint_disabled": true,
+		"top":               map[string]interface{}{"k": 5.0},
+	}}, l)
+
+	engine := gin.New()
+	engine.Use(metric.NewRouterMiddleware())
+	engine.GET("/foo", metric.NewHTTPHandlerFactory(krakendgin.EndpointHandler)(&config.EndpointConfig{Endpoint: "/foo"}, proxy.NoopProxy))
+	for _, path := range []string{"/foo", "/foo", "/bar"} {
+		req, _ := http.NewRequest("GET", path, http.NoBody)
+		req.RemoteAddr = "1.2.3.4:5678"
+		engine.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/__stats/top", http.NoBody)
+	metric.NewEngine().ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("unexpected status code: %d", w.Code)
+	}
+	var report metrics.TopReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Paths) != 2 || report.Paths[0] != (metrics.TopEntry{Key: "/foo", Count: 2}) {
+		t.Errorf("unexpected paths: %+v", report.Paths)
+	}
+	if len(report.IPs) != 1 || report.IPs[0].Count != 3 {
+		t.Errorf("unexpected ips: %+v", report.IPs)
+	}
+}
+
+func TestNewEngine_pprof(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	buf := bytes.NewBuffer(make([]byte, 1024))
+	l, _ := logging.NewLogger("DEBUG", buf, "")
+	cfg := map[string]interface{}{metrics.Namespace: map[string]interface{}{
+		"endpoint_disabled": true,
+		"pprof":             map[string]interface{}{"max_duration": "5s"},
+		"auth": map[string]interface{}{
+			"allowed_cidrs": []interface{}{"127.0.0.0/8"},
+		},
+	}}
+	engine := New(ctx, cfg, l).NewEngine()
+
+	for _, tc := range []struct {
+		path       string
+		remoteAddr string
+		status     int
+	}{
+		{"/debug/pprof/heap", "127.0.0.1:1234", http.StatusOK},
+		{"/debug/pprof/heap", "10.0.0.1:1234", http.StatusForbidden},
+		{"/debug/pprof/profile?seconds=10", "127.0.0.1:1234", http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", tc.path, http.NoBody)
+		req.RemoteAddr = tc.remoteAddr
+		engine.ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Errorf("%s from %s: unexpected status code. have: %d, want: %d", tc.path, tc.remoteAddr, w.Code, tc.status)
+		}
+	}
+}
+
 func TestStatsEndpoint(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -139,3 +465,17 @@ func TestStatsEndpoint(t *testing.T) {
 		return
 	}
 }
+
+func TestNewFromServiceConfig(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l, _ := logging.NewLogger("DEBUG", new(bytes.Buffer), "")
+	cfg := config.ServiceConfig{
+		Endpoints:   []*config.EndpointConfig{{Endpoint: "/a", Backend: []*config.Backend{{URLPattern: "/a"}}}},
+		ExtraConfig: config.ExtraConfig{metrics.Namespace: map[string]interface{}{"endpoint_disabled": true}},
+	}
+	metric := NewFromServiceConfig(ctx, cfg, l)
+	if v := metric.TakeSnapshot().Gauges["krakend.service.config.backends"]; v != 1 {
+		t.Errorf("unexpected number of backends: %d", v)
+	}
+}
@@ -0,0 +1,50 @@
+package gin
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	metrics "github.com/krakend/krakend-metrics/v2"
+)
+
+// NewRouterMiddleware returns a middleware instrumenting the requests not matching any route, the ones
+// rejected because of their method and the recovered panics. It should be registered with engine.Use
+func (m *Metrics) NewRouterMiddleware() gin.HandlerFunc {
+	if m.Config == nil || m.Config.RouterDisabled {
+		return func(c *gin.Context) { c.Next() }
+	}
+	return NewRouterMiddleware(m.Router)
+}
+
+// NewRouterMiddleware returns a middleware instrumenting the requests not matching any route, the ones
+// rejected because of their method and the recovered panics. Requests matching a route are left to the
+// handlers created with NewHTTPHandlerFactory.
+func NewRouterMiddleware(rm *metrics.RouterMetrics) gin.HandlerFunc {
+	rm.RegisterUnmatchedMetrics()
+	return func(c *gin.Context) {
+		begin := rm.Now()
+
+		defer func() {
+			if v := recover(); v != nil {
+				rm.Panic(v)
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+				c.AbortWithStatus(http.StatusInternalServerError)
+			}
+		}()
+
+		c.Next()
+
+		if c.FullPath() != "" {
+			return
+		}
+		size := c.Writer.Size()
+		if size < 0 {
+			size = 0
+		}
+		rm.Unmatched(c.Writer.Status(), int64(size), rm.Now().Sub(begin))
+		rm.HeavyHitters().Record(c.Request)
+	}
+}
@@ -0,0 +1,76 @@
+package gin
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	metrics "github.com/krakend/krakend-metrics/v2"
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/proxy"
+	gometrics "github.com/rcrowley/go-metrics"
+)
+
+func TestNewRouterMiddleware(t *testing.T) {
+	registry := gometrics.NewRegistry()
+	rm := metrics.NewRouterMetrics(&registry)
+
+	hf := NewHTTPHandlerFactory(rm, func(_ *config.EndpointConfig, _ proxy.Proxy) gin.HandlerFunc {
+		return func(c *gin.Context) { c.String(200, "ok") }
+	})
+
+	engine := gin.New()
+	engine.HandleMethodNotAllowed = true
+	engine.Use(NewRouterMiddleware(rm))
+	engine.GET("/test", hf(&config.EndpointConfig{Endpoint: "/test"}, proxy.NoopProxy))
+	engine.GET("/panic", func(_ *gin.Context) { panic(errors.New("boom")) })
+	panicking := NewHTTPHandlerFactory(rm, func(_ *config.EndpointConfig, _ proxy.Proxy) gin.HandlerFunc {
+		return func(_ *gin.Context) { panic(errors.New("boom")) }
+	})
+	engine.GET("/instrumented-panic", panicking(&config.EndpointConfig{Endpoint: "/instrumented-panic"}, proxy.NoopProxy))
+
+	for _, tc := range []struct {
+		method string
+		path   string
+		status int
+	}{
+		{"GET", "/test", 200},
+		{"GET", "/unknown", 404},
+		{"GET", "/unknown", 404},
+		{"POST", "/test", 405},
+		{"GET", "/panic", 500},
+		{"GET", "/instrumented-panic", 500},
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(tc.method, tc.path, http.NoBody)
+		engine.ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Errorf("%s %s: unexpected status code: %d", tc.method, tc.path, w.Code)
+		}
+	}
+
+	for k, want := range map[string]int64{
+		"router.response./test.status.200.count":                1,
+		"router.response.__no_route.status.404.count":           2,
+		"router.response.__method_not_allowed.status.405.count": 1,
+		"router.response./instrumented-panic.status.500.count":  1,
+		"router.panic.errors_errorString.count":                 2,
+		// the panics of the instrumented routes still close their connections
+		"router.connected":    2,
+		"router.disconnected": 2,
+	} {
+		c, ok := registry.Get(k).(gometrics.Counter)
+		if !ok {
+			t.Errorf("counter %s not registered", k)
+			continue
+		}
+		if c.Count() != want {
+			t.Errorf("unexpected value for %s. have: %d, want: %d", k, c.Count(), want)
+		}
+	}
+	if h := registry.Get("router.response.__no_route.time").(gometrics.Histogram); h.Count() != 2 {
+		t.Errorf("unexpected number of unmatched responses: %d", h.Count())
+	}
+}
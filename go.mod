@@ -7,6 +7,7 @@ require (
 	github.com/krakend/krakend-koanf v0.0.0-20251111142508-ab36eebbcf9b
 	github.com/luraproject/lura/v2 v2.12.1
 	github.com/rcrowley/go-metrics v0.0.0-20180406234716-d932a24a8ccb
+	golang.org/x/crypto v0.52.0
 )
 
 require (
@@ -46,7 +47,6 @@ require (
 	github.com/valyala/fastrand v1.1.0 // indirect
 	go.yaml.in/yaml/v3 v3.0.3 // indirect
 	golang.org/x/arch v0.18.0 // indirect
-	golang.org/x/crypto v0.52.0 // indirect
 	golang.org/x/net v0.55.0 // indirect
 	golang.org/x/sys v0.45.0 // indirect
 	golang.org/x/text v0.37.0 // indirect
@@ -0,0 +1,116 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"strings"
+)
+
+// ReadinessConfig holds the conditions for reporting the gateway as ready
+type ReadinessConfig struct {
+	// MaxBackendErrorRatio is the maximum ratio of failed requests to a backend, during the last
+	// collection interval, tolerated before reporting the gateway as not ready. Zero disables the check
+	MaxBackendErrorRatio float64
+}
+
+func parseReadinessConfig(data map[string]interface{}) ReadinessConfig {
+	cfg := ReadinessConfig{}
+	tmp, ok := data["readiness"].(map[string]interface{})
+	if !ok {
+		return cfg
+	}
+	if ratio, ok := tmp["max_backend_error_ratio"].(float64); ok {
+		cfg.MaxBackendErrorRatio = ratio
+	}
+	return cfg
+}
+
+// ErrNoSnapshot is returned by Ready until the first collection tick has produced a snapshot
+var ErrNoSnapshot = errors.New("no snapshot collected yet")
+
+const backendRequestsPrefix = "krakend.proxy.requests.layer.backend.name."
+
+// Ready returns an error describing why the gateway should not receive traffic, or nil if it is ready
+func (m *Metrics) Ready() error {
+	m.snapshotMu.RLock()
+	defer m.snapshotMu.RUnlock()
+
+	if !m.collected {
+		return ErrNoSnapshot
+	}
+	if m.Config == nil || m.Config.Readiness.MaxBackendErrorRatio <= 0 {
+		return nil
+	}
+
+	type ratio struct{ errors, total int64 }
+	backends := map[string]*ratio{}
+	for k, v := range m.latestSnapshot.Counters {
+		if !strings.HasPrefix(k, backendRequestsPrefix) {
+			continue
+		}
+		idx := strings.LastIndex(k, ".complete.")
+		if idx < len(backendRequestsPrefix) {
+			continue
+		}
+		name := k[len(backendRequestsPrefix):idx]
+		r, ok := backends[name]
+		if !ok {
+			r = &ratio{}
+			backends[name] = r
+		}
+		delta := v - m.previousSnapshot.Counters[k]
+		r.total += delta
+		if strings.HasSuffix(k, ".error.true") {
+			r.errors += delta
+		}
+	}
+
+	for name, r := range backends {
+		if r.total <= 0 {
+			continue
+		}
+		if ratio := float64(r.errors) / float64(r.total); ratio > m.Config.Readiness.MaxBackendErrorRatio {
+			return fmt.Errorf("the error ratio of the backend %s is %.2f", name, ratio)
+		}
+	}
+	return nil
+}
+
+// BuildInfo describes the binary running the gateway
+type BuildInfo struct {
+	GoVersion string            `json:"go_version"`
+	Path      string            `json:"path"`
+	Main      Module            `json:"main"`
+	Deps      []Module          `json:"deps"`
+	Settings  map[string]string `json:"settings"`
+}
+
+// Module describes a go module linked into the binary
+type Module struct {
+	Path    string `json:"path"`
+	Version string `json:"version"`
+	Sum     string `json:"sum,omitempty"`
+}
+
+// ReadBuildInfo returns the build information embedded in the running binary
+func ReadBuildInfo() BuildInfo {
+	res := BuildInfo{Settings: map[string]string{}, Deps: []Module{}}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return res
+	}
+	res.GoVersion = info.GoVersion
+	res.Path = info.Path
+	res.Main = Module{Path: info.Main.Path, Version: info.Main.Version, Sum: info.Main.Sum}
+	for _, dep := range info.Deps {
+		if dep.Replace != nil {
+			dep = dep.Replace
+		}
+		res.Deps = append(res.Deps, Module{Path: dep.Path, Version: dep.Version, Sum: dep.Sum})
+	}
+	for _, setting := range info.Settings {
+		res.Settings[setting.Key] = setting.Value
+	}
+	return res
+}
@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMetrics_Ready(t *testing.T) {
+	m := Metrics{
+		Config:         &Config{Readiness: ReadinessConfig{MaxBackendErrorRatio: 0.5}},
+		latestSnapshot: NewStats(),
+	}
+	if err := m.Ready(); err != ErrNoSnapshot {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	key := backendRequestsPrefix + "/some/{url}.complete.%s.error.%s"
+	snapshot := NewStats()
+	snapshot.Counters["krakend.proxy.requests.layer.proxy.name./foo.complete.false.error.true"] = 100
+	snapshot.Counters[fmt.Sprintf(key, "true", "false")] = 10
+	snapshot.Counters[fmt.Sprintf(key, "false", "true")] = 2
+	m.storeSnapshot(snapshot)
+	if err := m.Ready(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	snapshot = NewStats()
+	snapshot.Counters[fmt.Sprintf(key, "true", "false")] = 12
+	snapshot.Counters[fmt.Sprintf(key, "false", "true")] = 10
+	m.storeSnapshot(snapshot)
+	if err := m.Ready(); err == nil || err.Error() != "the error ratio of the backend /some/{url} is 0.80" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	m.Config.Readiness.MaxBackendErrorRatio = 0
+	if err := m.Ready(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestReadBuildInfo(t *testing.T) {
+	info := ReadBuildInfo()
+	if info.GoVersion == "" {
+		t.Error("the go version should be present")
+	}
+	if info.Settings == nil || info.Deps == nil {
+		t.Error("the collections should not be nil")
+	}
+}
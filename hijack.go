@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Hijacked registers a connection taken over by the handler of the named endpoint (websockets and other
+// protocol upgrades) and returns a wrapper of the connection tracking its lifetime and the bytes moved in
+// both directions. The metrics are recorded when the returned connection is closed.
+func (rm *RouterMetrics) Hijacked(name string, conn net.Conn) net.Conn {
+	rm.Counter("hijacked", name, "count").Inc(1)
+	rm.Gauge("hijacked-gauge").Update(rm.hijacked.Add(1))
+
+	return &hijackedConn{
+		Conn:  conn,
+		name:  name,
+		begin: rm.Now(),
+		rm:    rm,
+	}
+}
+
+func (rm *RouterMetrics) hijackedClosed(c *hijackedConn) {
+	rm.Gauge("hijacked-gauge").Update(rm.hijacked.Add(-1))
+	rm.Histogram("hijacked", c.name, "time").Update(int64(rm.Now().Sub(c.begin)))
+	rm.Histogram("hijacked", c.name, "in").Update(c.in.Load())
+	rm.Histogram("hijacked", c.name, "out").Update(c.out.Load())
+}
+
+type hijackedConn struct {
+	net.Conn
+	name  string
+	begin time.Time
+	rm    *RouterMetrics
+	in    atomic.Int64
+	out   atomic.Int64
+	once  sync.Once
+}
+
+// Read implements the net.Conn interface
+func (c *hijackedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.in.Add(int64(n))
+	return n, err
+}
+
+// Write implements the net.Conn interface
+func (c *hijackedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.out.Add(int64(n))
+	return n, err
+}
+
+// Close implements the net.Conn interface
+func (c *hijackedConn) Close() error {
+	c.once.Do(func() { c.rm.hijackedClosed(c) })
+	return c.Conn.Close()
+}
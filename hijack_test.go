@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	metrics "github.com/rcrowley/go-metrics"
+)
+
+func TestRouterMetrics_Hijacked(t *testing.T) {
+	p := metrics.NewRegistry()
+	rm := NewRouterMetrics(&p)
+
+	server, client := net.Pipe()
+	conn := rm.Hijacked("ws", server)
+
+	if v := p.Get("router.hijacked-gauge").(metrics.Gauge).Value(); v != 1 {
+		t.Errorf("unexpected number of active connections: %d", v)
+	}
+
+	go func() {
+		client.Write([]byte("ping"))
+		buf := make([]byte, 6)
+		io.ReadFull(client, buf)
+		client.Close()
+	}()
+
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Error(err)
+	}
+	if _, err := conn.Write([]byte("pong!!")); err != nil {
+		t.Error(err)
+	}
+	conn.Close()
+	conn.Close()
+
+	if v := p.Get("router.hijacked-gauge").(metrics.Gauge).Value(); v != 0 {
+		t.Errorf("unexpected number of active connections: %d", v)
+	}
+	if v := p.Get("router.hijacked.ws.count").(metrics.Counter).Count(); v != 1 {
+		t.Errorf("unexpected number of upgrades: %d", v)
+	}
+	for k, want := range map[string]int64{
+		"router.hijacked.ws.in":  4,
+		"router.hijacked.ws.out": 6,
+	} {
+		h := p.Get(k).(metrics.Histogram)
+		if h.Count() != 1 || h.Max() != want {
+			t.Errorf("unexpected value for %s. count: %d, have: %d, want: %d", k, h.Count(), h.Max(), want)
+		}
+	}
+	if h := p.Get("router.hijacked.ws.time").(metrics.Histogram); h.Count() != 1 {
+		t.Errorf("unexpected lifetime records: %d", h.Count())
+	}
+}
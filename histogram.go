@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"sort"
+	"sync/atomic"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// cumulativeHistogram is a histogram keeping the number and the sum of its observations since its
+// creation, so the totals exposed to the scrapers are monotonic even if the sample is cleared by every
+// snapshot. It also counts the observations falling into its fixed buckets, if any
+type cumulativeHistogram struct {
+	metrics.Histogram
+	count atomic.Int64
+	sum   atomic.Int64
+	// bounds are the sorted upper bounds of the buckets and buckets the number of observations of every
+	// bucket (not cumulative). The observations above the last bound are only in the count
+	bounds  []int64
+	buckets []atomic.Int64
+}
+
+func newHistogram() metrics.Histogram {
+	return &cumulativeHistogram{Histogram: metrics.NewHistogram(defaultSample())}
+}
+
+func newBucketedHistogram(bounds []int64) metrics.Histogram {
+	return &cumulativeHistogram{
+		Histogram: metrics.NewHistogram(defaultSample()),
+		bounds:    bounds,
+		buckets:   make([]atomic.Int64, len(bounds)),
+	}
+}
+
+// Update implements the metrics.Histogram interface
+func (h *cumulativeHistogram) Update(v int64) {
+	h.Histogram.Update(v)
+	h.count.Add(1)
+	h.sum.Add(v)
+	if i := sort.Search(len(h.bounds), func(i int) bool { return v <= h.bounds[i] }); i < len(h.bounds) {
+		h.buckets[i].Add(1)
+	}
+}
+
+// Totals returns the number and the sum of the observations since the creation of the histogram
+func (h *cumulativeHistogram) Totals() (count, sum int64) {
+	return h.count.Load(), h.sum.Load()
+}
+
+// Buckets returns the upper bounds of the buckets and the cumulative number of observations below or
+// equal to each of them since the creation of the histogram. The bounds are empty if the histogram has
+// no buckets. Read the buckets before the totals, so the count is never below the last bucket
+func (h *cumulativeHistogram) Buckets() (bounds, counts []int64) {
+	counts = make([]int64, len(h.bounds))
+	var total int64
+	for i := range h.buckets {
+		total += h.buckets[i].Load()
+		counts[i] = total
+	}
+	return h.bounds, counts
+}
+
+// getOrRegisterHistogram returns the histogram registered with the name, registering a cumulative
+// histogram if there is none. A nil registry means the default one, as in metrics.GetOrRegisterHistogram
+func getOrRegisterHistogram(name string, r metrics.Registry) metrics.Histogram {
+	return getOrRegisterBucketedHistogram(name, r, nil)
+}
+
+// getOrRegisterBucketedHistogram returns the histogram registered with the name, registering a
+// cumulative histogram with the bucket bounds if there is none
+func getOrRegisterBucketedHistogram(name string, r metrics.Registry, bounds []int64) metrics.Histogram {
+	if r == nil {
+		r = metrics.DefaultRegistry
+	}
+	if len(bounds) == 0 {
+		return r.GetOrRegister(name, newHistogram).(metrics.Histogram)
+	}
+	return r.GetOrRegister(name, func() metrics.Histogram { return newBucketedHistogram(bounds) }).(metrics.Histogram)
+}
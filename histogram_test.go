@@ -0,0 +1,26 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestCumulativeHistogram(t *testing.T) {
+	r := metrics.NewRegistry()
+	h := getOrRegisterHistogram("a.time", r)
+	if getOrRegisterHistogram("a.time", r) != h {
+		t.Error("the registered histogram should be reused")
+	}
+	h.Update(10)
+	h.Update(20)
+	h.Clear()
+	h.Update(5)
+
+	if h.Count() != 1 || h.Sum() != 5 {
+		t.Errorf("unexpected sample. count: %d, sum: %d", h.Count(), h.Sum())
+	}
+	if count, sum := h.(*cumulativeHistogram).Totals(); count != 3 || sum != 35 {
+		t.Errorf("unexpected totals. count: %d, sum: %d", count, sum)
+	}
+}
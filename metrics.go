@@ -8,6 +8,7 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/luraproject/lura/v2/config"
@@ -43,6 +44,54 @@ func New(ctx context.Context, e config.ExtraConfig, l logging.Logger) *Metrics {
 		Registry:       &registry,
 		latestSnapshot: NewStats(),
 	}
+	m.Router.EnableConsumerMetrics(cfg.Consumers)
+	m.Router.EnableApdex(cfg.Apdex)
+	if cfg.SlowRequests != nil {
+		slow := NewSlowRequests(cfg.SlowRequests, "krakend.", l)
+		m.Router.EnableSlowRequests(slow)
+		m.Proxy.EnableSlowRequests(slow)
+	}
+	if cfg.Top != nil {
+		h, err := NewHeavyHitters(cfg.Top)
+		if err != nil {
+			l.Error("[SERVICE: Stats] Invalid top config, the heavy hitters are not tracked:", err.Error())
+		} else {
+			m.Router.EnableHeavyHitters(h)
+		}
+	}
+
+	auth, err := NewAuthenticator(cfg.Auth)
+	if err != nil {
+		l.Error("[SERVICE: Stats] Invalid auth config, denying every request to the stats server:", err.Error())
+		auth = &Authenticator{denyAll: true}
+	}
+	m.auth = auth
+
+	m.state = newStateStore(cfg.State, l)
+	if m.state != nil {
+		if err := m.state.restore(registry); err != nil {
+			l.Error("[SERVICE: Stats] Unable to restore the counters, starting from zero:", err.Error())
+		}
+	}
+	serviceRegistry := metrics.NewPrefixedChildRegistry(registry, "service.")
+	m.sinks = newSinks(ctx, cfg.Sinks, serviceRegistry, l)
+	m.slos = newSLOs(cfg.SLOs, m.Router, m.Proxy, serviceRegistry, time.Now())
+	if cfg.Anomalies != nil {
+		d, err := NewAnomalyDetector(cfg.Anomalies, "krakend.", serviceRegistry)
+		if err != nil {
+			l.Error("[SERVICE: Stats] Invalid anomalies config, the anomalies are not detected:", err.Error())
+		} else {
+			m.anomalies = d
+		}
+	}
+	if cfg.Alerts != nil {
+		a, err := NewAlerter(cfg.Alerts, serviceRegistry, l)
+		if err != nil {
+			l.Error("[SERVICE: Stats] Invalid alerts config, the alert rules are not evaluated:", err.Error())
+		} else {
+			m.alerts = a
+		}
+	}
 
 	m.processMetrics(ctx, m.Config.CollectionTime, logger{l})
 
@@ -60,6 +109,20 @@ type Config struct {
 	CollectionTime   time.Duration
 	ListenAddr       string
 	EndpointDisabled bool
+	Auth             *AuthConfig
+	TLS              *TLSConfig
+	Readiness        ReadinessConfig
+	Pprof            *PprofConfig
+	MemStatsDisabled bool
+	Sinks            []SinkConfig
+	State            *StateConfig
+	Consumers        *ConsumerConfig
+	Top              *TopConfig
+	SlowRequests     *SlowRequestsConfig
+	Apdex            *ApdexConfig
+	SLOs             []SLOConfig
+	Alerts           *AlertsConfig
+	Anomalies        *AnomaliesConfig
 }
 
 // ConfigGetter implements the config.ConfigGetter interface. It parses the extra config for the
@@ -92,6 +155,20 @@ func ConfigGetter(e config.ExtraConfig) interface{} {
 	userCfg.RouterDisabled = getBool(tmp, "router_disabled")
 	userCfg.BackendDisabled = getBool(tmp, "backend_disabled")
 	userCfg.EndpointDisabled = getBool(tmp, "endpoint_disabled")
+	userCfg.MemStatsDisabled = getBool(tmp, "memstats_disabled")
+	userCfg.Auth = parseAuthConfig(tmp)
+	userCfg.TLS = parseTLSConfig(tmp)
+	userCfg.Readiness = parseReadinessConfig(tmp)
+	userCfg.Pprof = parsePprofConfig(tmp)
+	userCfg.Sinks = parseSinksConfig(tmp)
+	userCfg.State = parseStateConfig(tmp)
+	userCfg.Consumers = parseConsumerConfig(tmp)
+	userCfg.Top = parseTopConfig(tmp)
+	userCfg.SlowRequests = parseSlowRequestsConfig(tmp)
+	userCfg.Apdex = parseApdexConfig(tmp)
+	userCfg.SLOs = parseSLOsConfig(tmp)
+	userCfg.Alerts = parseAlertsConfig(tmp)
+	userCfg.Anomalies = parseAnomaliesConfig(tmp)
 
 	return userCfg
 }
@@ -114,15 +191,59 @@ type Metrics struct {
 	// Router is the metrics collector for the router package
 	Router *RouterMetrics
 	// Registry is the metrics register
-	Registry       *metrics.Registry
-	latestSnapshot Stats
+	Registry         *metrics.Registry
+	latestSnapshot   Stats
+	previousSnapshot Stats
+	collected        bool
+	snapshotMu       sync.RWMutex
+	auth             *Authenticator
+	subs             subscribers
+	sinks            sinks
+	state            *stateStore
+	slos             *SLOs
+	alerts           *Alerter
+	anomalies        *AnomalyDetector
+	done             chan struct{}
+}
+
+// closedDone is the Done channel of the collectors without a collection loop
+var closedDone = func() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}()
+
+// Done returns a channel closed when the collector has stopped after the cancellation of its context:
+// the last checkpoint is written and the subscribers, the sinks and the alerts are closed. It is already
+// closed for the collectors without a collection loop
+func (m *Metrics) Done() <-chan struct{} {
+	if m.done == nil {
+		return closedDone
+	}
+	return m.done
+}
+
+// Authenticator returns the access control rules of the stats server. It is nil if no rules are defined
+func (m *Metrics) Authenticator() *Authenticator {
+	return m.auth
 }
 
 // Snapshot returns the last calculted snapshot
 func (m *Metrics) Snapshot() Stats {
+	m.snapshotMu.RLock()
+	defer m.snapshotMu.RUnlock()
 	return m.latestSnapshot
 }
 
+func (m *Metrics) storeSnapshot(s Stats) {
+	m.snapshotMu.Lock()
+	m.previousSnapshot = m.latestSnapshot
+	m.latestSnapshot = s
+	m.collected = true
+	m.snapshotMu.Unlock()
+	m.publish(s)
+}
+
 // TakeSnapshot takes a snapshot of the current state
 func (m *Metrics) TakeSnapshot() Stats {
 	tmp := NewStats()
@@ -133,6 +254,8 @@ func (m *Metrics) TakeSnapshot() Stats {
 			tmp.Counters[k] = metric.Count()
 		case metrics.Gauge:
 			tmp.Gauges[k] = metric.Value()
+		case metrics.GaugeFloat64:
+			tmp.FloatGauges[k] = metric.Value()
 		case metrics.Histogram:
 			tmp.Histograms[k] = HistogramData{
 				Max:         metric.Max(),
@@ -148,22 +271,60 @@ func (m *Metrics) TakeSnapshot() Stats {
 	return tmp
 }
 
-func (m *Metrics) processMetrics(ctx context.Context, d time.Duration, _ metrics.Logger) {
+func (m *Metrics) processMetrics(ctx context.Context, d time.Duration, l metrics.Logger) {
 	r := metrics.NewPrefixedChildRegistry(*(m.Registry), "service.")
 
-	metrics.RegisterDebugGCStats(r)
-	metrics.RegisterRuntimeMemStats(r)
+	memStats := m.Config == nil || !m.Config.MemStatsDisabled
+	if memStats {
+		metrics.RegisterDebugGCStats(r)
+		metrics.RegisterRuntimeMemStats(r)
+	}
+	runtimeCollector := newRuntimeCollector(r)
+	processCollector := newProcessCollector(r)
+	processErrLogged := false
+	uptime := metrics.GetOrRegisterGauge("uptime", r)
 
+	m.done = make(chan struct{})
 	go func() {
+		defer close(m.done)
 		ticker := time.NewTicker(d)
+		var checkpoints <-chan time.Time
+		if m.state != nil {
+			checkpointTicker := time.NewTicker(m.state.cfg.CheckpointInterval)
+			defer checkpointTicker.Stop()
+			checkpoints = checkpointTicker.C
+		}
 		for {
 			select {
+			case <-checkpoints:
+				m.state.checkpoint(*m.Registry)
 			case <-ticker.C:
-				metrics.CaptureDebugGCStatsOnce(r)
-				metrics.CaptureRuntimeMemStatsOnce(r)
+				if memStats {
+					metrics.CaptureDebugGCStatsOnce(r)
+					metrics.CaptureRuntimeMemStatsOnce(r)
+				}
+				uptime.Update(int64(time.Since(processStart)))
+				runtimeCollector.collect()
+				if err := processCollector.collect(); err != nil && !processErrLogged {
+					l.Printf("unable to collect the process metrics: %s", err)
+					processErrLogged = true
+				}
 				m.Router.Aggregate()
-				m.latestSnapshot = m.TakeSnapshot()
+				m.Router.HeavyHitters().Rotate()
+				m.Router.Apdex().Update()
+				m.slos.update(time.Now())
+				snapshot := m.TakeSnapshot()
+				m.anomalies.Observe(&snapshot)
+				m.storeSnapshot(snapshot)
+				m.sinks.export(snapshot)
+				m.alerts.Evaluate(snapshot)
 			case <-ctx.Done():
+				ticker.Stop()
+				// the checkpoint goes first, so a slow sink or webhook does not risk the counters
+				m.state.checkpoint(*m.Registry)
+				m.closeSubscribers()
+				m.sinks.close()
+				m.alerts.Close()
 				return
 			}
 		}
@@ -39,6 +39,19 @@ func TestConfigGetter(t *testing.T) {
 	}
 }
 
+func TestConfigGetter_memStats(t *testing.T) {
+	testCfg := ConfigGetter(map[string]interface{}{Namespace: map[string]interface{}{}}).(*Config)
+	if testCfg.MemStatsDisabled {
+		t.Error("MemStats should be enabled by default.")
+	}
+	testCfg = ConfigGetter(map[string]interface{}{
+		Namespace: map[string]interface{}{"memstats_disabled": true},
+	}).(*Config)
+	if !testCfg.MemStatsDisabled {
+		t.Error("MemStats should be disabled.")
+	}
+}
+
 func TestDefaultConfiguration(t *testing.T) {
 	errorCfg := map[string]interface{}{
 		Namespace: map[string]interface{}{
@@ -116,6 +129,17 @@ func TestBadConfiguration(t *testing.T) {
 	}
 }
 
+func TestMetrics_Done(t *testing.T) {
+	l, _ := logging.NewLogger("DEBUG", new(bytes.Buffer), "")
+	for _, m := range []*Metrics{New(context.Background(), nil, l), {}} {
+		select {
+		case <-m.Done():
+		default:
+			t.Error("the collectors without a collection loop should be done")
+		}
+	}
+}
+
 func TestMetrics(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
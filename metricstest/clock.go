@@ -0,0 +1,44 @@
+package metricstest
+
+import (
+	"sync"
+	"time"
+)
+
+// FakeClock is a clock only moving when told to, so the durations measured by the collectors are
+// deterministic. It is safe for concurrent use
+type FakeClock struct {
+	mu   sync.Mutex
+	now  time.Time
+	step time.Duration
+}
+
+// NewFakeClock creates a fake clock stopped at the given time
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+// Now returns the current time of the clock and advances it by the step, if any. Use it as the clock
+// of the collectors
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now
+	c.now = c.now.Add(c.step)
+	return now
+}
+
+// Advance moves the clock forward
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+// SetStep makes every call to Now advance the clock by the given duration, so every measured duration
+// is a multiple of it. A zero step stops the clock again
+func (c *FakeClock) SetStep(d time.Duration) {
+	c.mu.Lock()
+	c.step = d
+	c.mu.Unlock()
+}
@@ -0,0 +1,28 @@
+package metricstest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewFakeClock(start)
+	if now := c.Now(); !now.Equal(start) {
+		t.Errorf("unexpected time: %s", now)
+	}
+	c.Advance(time.Second)
+	if now := c.Now(); !now.Equal(start.Add(time.Second)) {
+		t.Errorf("unexpected time after advancing the clock: %s", now)
+	}
+
+	c.SetStep(time.Millisecond)
+	begin := c.Now()
+	if d := c.Now().Sub(begin); d != time.Millisecond {
+		t.Errorf("unexpected duration with a step: %s", d)
+	}
+	c.SetStep(0)
+	if c.Now() != c.Now() {
+		t.Error("the clock should be stopped")
+	}
+}
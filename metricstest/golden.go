@@ -0,0 +1,86 @@
+package metricstest
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	krakendmetrics "github.com/krakend/krakend-metrics/v2"
+)
+
+// UpdateEnv is the environment variable making AssertGolden write the golden files instead of checking
+// them. Ex: METRICSTEST_UPDATE=1 go test ./...
+const UpdateEnv = "METRICSTEST_UPDATE"
+
+// AssertGolden compares the snapshot with the one stored as indented JSON in the golden file. The time
+// of the snapshot is ignored. When the UpdateEnv environment variable is set, the golden file is
+// written with the snapshot instead
+func AssertGolden(t testing.TB, path string, s krakendmetrics.Stats) {
+	t.Helper()
+	s.Time = 0
+	have, err := json.MarshalIndent(s, "", "\t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	have = append(have, '\n')
+
+	if os.Getenv(UpdateEnv) != "" {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, have, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unable to read the golden file (set %s to create it): %s", UpdateEnv, err)
+	}
+	if bytes.Equal(have, want) {
+		return
+	}
+	t.Errorf("the snapshot does not match the golden file %s (set %s to update it):\n%s",
+		path, UpdateEnv, diffLines(strings.Split(string(want), "\n"), strings.Split(string(have), "\n")))
+}
+
+// diffLines returns the lines of both versions, prefixing the ones only in the wanted version with "- "
+// and the ones only in the version we have with "+ "
+func diffLines(want, have []string) string {
+	// lcs[i][j] is the length of the longest common subsequence of want[i:] and have[j:]
+	lcs := make([][]int, len(want)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(have)+1)
+	}
+	for i := len(want) - 1; i >= 0; i-- {
+		for j := len(have) - 1; j >= 0; j-- {
+			if want[i] == have[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var b strings.Builder
+	i, j := 0, 0
+	for i < len(want) || j < len(have) {
+		switch {
+		case i < len(want) && j < len(have) && want[i] == have[j]:
+			b.WriteString("  " + want[i] + "\n")
+			i++
+			j++
+		case i < len(want) && (j == len(have) || lcs[i+1][j] >= lcs[i][j+1]):
+			b.WriteString("- " + want[i] + "\n")
+			i++
+		default:
+			b.WriteString("+ " + have[j] + "\n")
+			j++
+		}
+	}
+	return b.String()
+}
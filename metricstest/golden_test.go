@@ -0,0 +1,54 @@
+package metricstest
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/luraproject/lura/v2/proxy"
+)
+
+func TestAssertGolden(t *testing.T) {
+	r := NewRecorder()
+	backend := func(_ context.Context, _ *proxy.Request) (*proxy.Response, error) {
+		r.Clock.Advance(20 * time.Millisecond)
+		return &proxy.Response{IsComplete: true}, nil
+	}
+	p := r.NewProxyMiddleware("backend", "/foo")(backend)
+	for i := 0; i < 3; i++ {
+		p(context.Background(), &proxy.Request{})
+	}
+	r.AssertHistogramCount(t, "proxy", "latency", Labels{"*"}, 3)
+
+	AssertGolden(t, filepath.Join("testdata", "proxy.golden.json"), r.TakeSnapshot())
+}
+
+func TestAssertGolden_update(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "golden", "snapshot.json")
+	r := NewRecorder()
+	r.Router.Counter("response", "/foo", "status", "200", "count").Inc(1)
+
+	t.Setenv(UpdateEnv, "1")
+	AssertGolden(t, path, r.TakeSnapshot())
+
+	t.Setenv(UpdateEnv, "")
+	AssertGolden(t, path, r.TakeSnapshot())
+
+	r.Router.Counter("response", "/foo", "status", "200", "count").Inc(1)
+	tb := &recordingTB{TB: t}
+	AssertGolden(tb, path, r.TakeSnapshot())
+	if len(tb.errors) != 1 || !strings.Contains(tb.errors[0], "- \t\t\"krakend.router.response./foo.status.200.count\": 1\n") ||
+		!strings.Contains(tb.errors[0], "+ \t\t\"krakend.router.response./foo.status.200.count\": 2\n") {
+		t.Errorf("unexpected errors: %v", tb.errors)
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	have := diffLines([]string{"a", "b", "c", "d"}, []string{"a", "x", "c", "d", "e"})
+	want := "  a\n- b\n+ x\n  c\n  d\n+ e\n"
+	if have != want {
+		t.Errorf("unexpected diff:\n%s", have)
+	}
+}
@@ -0,0 +1,167 @@
+// Package metricstest provides the building blocks for testing the components instrumented with the
+// krakend-metrics collectors: an in-memory recorder, assertions over the recorded metrics, a golden file
+// comparator for the snapshots and a fake clock
+package metricstest
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+
+	krakendmetrics "github.com/krakend/krakend-metrics/v2"
+)
+
+// Prefix is the prefix of the names of the metrics recorded, as in the collectors created by
+// krakendmetrics.New
+const Prefix = "krakend."
+
+// DefaultTimeout is the max time the assertions of a new recorder wait for the expected value
+const DefaultTimeout = time.Second
+
+// start is the initial time of the clock of the recorders
+var start = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Labels are the segments of a metric name following the layer and the name, in order. A "*" segment
+// matches any value, and the assertions aggregate all the metrics matching it
+type Labels []string
+
+// ProxyLabels returns the labels of the metrics of a proxy middleware created with the given layer and
+// name (ex: "backend" and the URL pattern)
+func ProxyLabels(layer, name string, complete, errored bool) Labels {
+	return Labels{"layer", layer, "name", name, "complete", strconv.FormatBool(complete), "error", strconv.FormatBool(errored)}
+}
+
+// Recorder is a metrics collector keeping the metrics in memory, without collection ticks or stats
+// server, so the tests can inspect them. Its router and proxy collectors measure the durations with
+// the fake Clock
+type Recorder struct {
+	*krakendmetrics.Metrics
+	Clock *FakeClock
+	// Start is the initial time of the Clock
+	Start time.Time
+	// Timeout is the max time the assertions wait for the expected value, since some metrics are
+	// recorded asynchronously (like the ones of the proxy middlewares)
+	Timeout time.Duration
+}
+
+// NewRecorder creates a recorder with every layer enabled, the DefaultTimeout and a fake clock stopped
+// at 2024-01-01T00:00:00Z
+func NewRecorder() *Recorder {
+	registry := metrics.NewPrefixedRegistry(Prefix)
+	m := &krakendmetrics.Metrics{
+		Config:   &krakendmetrics.Config{CollectionTime: time.Minute},
+		Router:   krakendmetrics.NewRouterMetrics(&registry),
+		Proxy:    krakendmetrics.NewProxyMetrics(&registry),
+		Registry: &registry,
+	}
+	clock := NewFakeClock(start)
+	m.Router.SetClock(clock.Now)
+	m.Proxy.SetClock(clock.Now)
+	return &Recorder{Metrics: m, Clock: clock, Start: start, Timeout: DefaultTimeout}
+}
+
+// AssertCounter checks the sum of the counters matching the layer (router, proxy or service), the name
+// and the labels, waiting up to the Timeout of the recorder for it
+func (r *Recorder) AssertCounter(t testing.TB, layer, name string, labels Labels, want int64) {
+	t.Helper()
+	r.assert(t, "counter", layer, name, labels, want, func(v interface{}) (int64, bool) {
+		c, ok := v.(metrics.Counter)
+		if !ok {
+			return 0, false
+		}
+		return c.Count(), true
+	})
+}
+
+// AssertGauge checks the sum of the gauges matching the layer, the name and the labels, waiting up to
+// the Timeout of the recorder for it
+func (r *Recorder) AssertGauge(t testing.TB, layer, name string, labels Labels, want int64) {
+	t.Helper()
+	r.assert(t, "gauge", layer, name, labels, want, func(v interface{}) (int64, bool) {
+		g, ok := v.(metrics.Gauge)
+		if !ok {
+			return 0, false
+		}
+		return g.Value(), true
+	})
+}
+
+// AssertHistogramCount checks the number of observations of the histograms matching the layer, the
+// name and the labels, waiting up to the Timeout of the recorder for it. The histograms are cleared by
+// every snapshot
+func (r *Recorder) AssertHistogramCount(t testing.TB, layer, name string, labels Labels, want int64) {
+	t.Helper()
+	r.assert(t, "histogram", layer, name, labels, want, func(v interface{}) (int64, bool) {
+		h, ok := v.(metrics.Histogram)
+		if !ok {
+			return 0, false
+		}
+		return h.Count(), true
+	})
+}
+
+// Histogram returns the values observed by the histograms matching the layer, the name and the labels
+func (r *Recorder) Histogram(layer, name string, labels Labels) []int64 {
+	res := []int64{}
+	re := metricPattern(layer, name, labels)
+	(*r.Registry).Each(func(k string, v interface{}) {
+		if h, ok := v.(metrics.Histogram); ok && re.MatchString(k) {
+			res = append(res, h.Sample().Values()...)
+		}
+	})
+	return res
+}
+
+func (r *Recorder) assert(t testing.TB, kind, layer, name string, labels Labels, want int64, value func(interface{}) (int64, bool)) {
+	t.Helper()
+	re := metricPattern(layer, name, labels)
+	sum := func() (int64, int) {
+		var total int64
+		var found int
+		(*r.Registry).Each(func(k string, v interface{}) {
+			if !re.MatchString(k) {
+				return
+			}
+			if n, ok := value(v); ok {
+				total += n
+				found++
+			}
+		})
+		return total, found
+	}
+
+	deadline := time.Now().Add(r.Timeout)
+	for {
+		have, found := sum()
+		if have == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			if found == 0 {
+				t.Errorf("no %s matching %s", kind, re)
+				return
+			}
+			t.Errorf("unexpected value of the %s %s (%d metrics): have %d, want %d", kind, re, found, have, want)
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// metricPattern returns the regexp matching the full name of the metrics with the layer, the name and
+// the labels
+func metricPattern(layer, name string, labels Labels) *regexp.Regexp {
+	parts := []string{regexp.QuoteMeta(Prefix + layer + "." + name)}
+	for _, l := range labels {
+		if l == "*" {
+			parts = append(parts, ".+")
+			continue
+		}
+		parts = append(parts, regexp.QuoteMeta(l))
+	}
+	return regexp.MustCompile("^" + strings.Join(parts, `\.`) + "$")
+}
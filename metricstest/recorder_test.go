@@ -0,0 +1,84 @@
+package metricstest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/luraproject/lura/v2/proxy"
+)
+
+func TestRecorder_proxyMiddleware(t *testing.T) {
+	r := NewRecorder()
+	backend := func(_ context.Context, req *proxy.Request) (*proxy.Response, error) {
+		r.Clock.Advance(50 * time.Millisecond)
+		if req.Path == "/fail" {
+			return nil, errors.New("boom")
+		}
+		return &proxy.Response{IsComplete: true}, nil
+	}
+	p := r.NewProxyMiddleware("backend", "/foo")(backend)
+
+	for _, path := range []string{"/ok", "/ok", "/fail"} {
+		p(context.Background(), &proxy.Request{Path: path})
+	}
+
+	r.AssertCounter(t, "proxy", "requests", ProxyLabels("backend", "/foo", true, false), 2)
+	r.AssertCounter(t, "proxy", "requests", ProxyLabels("backend", "/foo", false, true), 1)
+	r.AssertCounter(t, "proxy", "requests", Labels{"layer", "backend", "name", "/foo", "*"}, 3)
+	r.AssertHistogramCount(t, "proxy", "latency", ProxyLabels("backend", "/foo", true, false), 2)
+	r.AssertHistogramCount(t, "proxy", "latency", Labels{"*"}, 3)
+
+	for _, v := range r.Histogram("proxy", "latency", Labels{"*"}) {
+		if v != int64(50*time.Millisecond) {
+			t.Errorf("unexpected latency: %d", v)
+		}
+	}
+}
+
+func TestRecorder_router(t *testing.T) {
+	r := NewRecorder()
+	r.Router.Connection(nil)
+	r.Router.Aggregate()
+	r.Router.Counter("response", "/foo", "status", "200", "count").Inc(2)
+
+	r.AssertGauge(t, "router", "connected-gauge", nil, 1)
+	r.AssertCounter(t, "router", "connected-total", nil, 1)
+	r.AssertCounter(t, "router", "response", Labels{"/foo", "status", "200", "count"}, 2)
+}
+
+func TestRecorder_assertionErrors(t *testing.T) {
+	r := NewRecorder()
+	r.Timeout = 10 * time.Millisecond
+	r.Router.Counter("response", "/foo", "status", "200", "count").Inc(2)
+
+	tb := &recordingTB{TB: t}
+	r.AssertCounter(tb, "router", "response", Labels{"/foo", "status", "*", "count"}, 1)
+	r.AssertHistogramCount(tb, "router", "response", Labels{"/bar", "time"}, 1)
+	want := []string{
+		`unexpected value of the counter ^krakend\.router\.response\./foo\.status\..+\.count$ (1 metrics): have 2, want 1`,
+		`no histogram matching ^krakend\.router\.response\./bar\.time$`,
+	}
+	if len(tb.errors) != len(want) {
+		t.Fatalf("unexpected errors: %v", tb.errors)
+	}
+	for i := range want {
+		if tb.errors[i] != want[i] {
+			t.Errorf("unexpected error #%d: %s", i, tb.errors[i])
+		}
+	}
+}
+
+// recordingTB records the errors instead of failing the test
+type recordingTB struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recordingTB) Helper() {}
+
+func (r *recordingTB) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,56 @@
+package mux
+
+import (
+	"encoding/json"
+	"net/http"
+
+	krakendmetrics "github.com/krakend/krakend-metrics/v2"
+)
+
+// NewHealthHandler creates an http.Handler reporting the liveness of the stats server
+func (*Metrics) NewHealthHandler() http.Handler {
+	return NewHealthHandler()
+}
+
+// NewReadyHandler creates an http.Handler reporting the readiness of the gateway
+func (m *Metrics) NewReadyHandler() http.Handler {
+	return NewReadyHandler(m.Metrics)
+}
+
+// NewVersionHandler creates an http.Handler exposing the build info of the gateway binary
+func (*Metrics) NewVersionHandler() http.Handler {
+	return NewVersionHandler()
+}
+
+// NewHealthHandler creates an http.Handler reporting the liveness of the stats server
+func NewHealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+}
+
+// NewReadyHandler creates an http.Handler replying with a 503 Service Unavailable while the metrics
+// collector reports the gateway as not ready
+func NewReadyHandler(m *krakendmetrics.Metrics) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if err := m.Ready(); err != nil {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "not ready", "reason": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+	})
+}
+
+// NewVersionHandler creates an http.Handler exposing the build info of the gateway binary
+func NewVersionHandler() http.Handler {
+	info := krakendmetrics.ReadBuildInfo()
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, info)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
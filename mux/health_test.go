@@ -0,0 +1,53 @@
+package mux
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	krakendmetrics "github.com/krakend/krakend-metrics/v2"
+	"github.com/luraproject/lura/v2/logging"
+)
+
+func TestNewEngine_health(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	buf := bytes.NewBuffer(make([]byte, 1024))
+	l, _ := logging.NewLogger("DEBUG", buf, "")
+	cfg := map[string]interface{}{krakendmetrics.Namespace: map[string]interface{}{
+		"collection_time":   "100ms",
+		"endpoint_disabled": true,
+	}}
+	engine := New(ctx, cfg, l).NewEngine()
+
+	get := func(path string) (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, http.NoBody)
+		engine.ServeHTTP(w, req)
+		var body map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Errorf("%s: unexpected body: %s", path, w.Body.String())
+		}
+		return w.Code, body
+	}
+
+	if status, body := get("/__health"); status != http.StatusOK || body["status"] != "ok" {
+		t.Errorf("unexpected health response: %d %v", status, body)
+	}
+	if status, body := get("/__ready"); status != http.StatusServiceUnavailable || body["status"] != "not ready" {
+		t.Errorf("unexpected readiness response before the first tick: %d %v", status, body)
+	}
+	if status, body := get("/__version"); status != http.StatusOK || body["go_version"] == "" {
+		t.Errorf("unexpected version response: %d %v", status, body)
+	}
+
+	time.Sleep(250 * time.Millisecond)
+
+	if status, body := get("/__ready"); status != http.StatusOK || body["status"] != "ready" {
+		t.Errorf("unexpected readiness response: %d %v", status, body)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"bufio"
 	"context"
 	"errors"
+	"io"
 	"net"
 	"net/http"
 	"strconv"
@@ -30,6 +31,16 @@ func New(ctx context.Context, e config.ExtraConfig, l logging.Logger) *Metrics {
 	return &metricsCollector
 }
 
+// NewFromServiceConfig creates a new metrics producer with support for the mux router, registering the
+// gauges describing the service config
+func NewFromServiceConfig(ctx context.Context, cfg config.ServiceConfig, l logging.Logger) *Metrics {
+	metricsCollector := Metrics{krakendmetrics.NewFromServiceConfig(ctx, cfg, l)}
+	if metricsCollector.Config != nil && !metricsCollector.Config.EndpointDisabled {
+		metricsCollector.RunEndpoint(ctx, metricsCollector.NewEngine(), l)
+	}
+	return &metricsCollector
+}
+
 // Metrics is the component that manages all the metrics for the mux-based gateways
 type Metrics struct {
 	*krakendmetrics.Metrics
@@ -42,7 +53,19 @@ func (m *Metrics) RunEndpoint(ctx context.Context, s *http.ServeMux, l logging.L
 		Handler:           s,
 		ReadHeaderTimeout: 3 * time.Second,
 	}
+	if m.Config.TLS != nil {
+		tlsCfg, err := krakendmetrics.NewServerTLSConfig(m.Config.TLS)
+		if err != nil {
+			l.Error("unable to start the stats handler with TLS:", err)
+			return
+		}
+		server.TLSConfig = tlsCfg
+	}
 	go func() {
+		if server.TLSConfig != nil {
+			l.Error(server.ListenAndServeTLS("", ""))
+			return
+		}
 		l.Error(server.ListenAndServe())
 	}()
 
@@ -55,16 +78,28 @@ func (m *Metrics) RunEndpoint(ctx context.Context, s *http.ServeMux, l logging.L
 	}()
 }
 
-// NewEngine returns a *http.ServeMux with the stats endpoint (no logger)
+// NewEngine returns a *http.ServeMux with the stats endpoint (no logger). Every route is protected by
+// the access control rules of the stats server, if any
 func (m *Metrics) NewEngine() *http.ServeMux {
+	auth := m.Authenticator()
 	mux := http.NewServeMux()
-	mux.Handle("/__stats", m.NewExpHandler())
+	mux.Handle("/__stats", auth.Handler(m.NewExpHandler()))
+	mux.Handle("/__stats/stream", auth.Handler(m.NewStreamHandler()))
+	mux.Handle("/__stats/ui", auth.Handler(m.NewUIHandler()))
+	mux.Handle("/__stats/top", auth.Handler(m.NewTopHandler()))
+	mux.Handle("/__health", auth.Handler(m.NewHealthHandler()))
+	mux.Handle("/__ready", auth.Handler(m.NewReadyHandler()))
+	mux.Handle("/__version", auth.Handler(m.NewVersionHandler()))
+	if m.Config != nil && m.Config.Pprof != nil {
+		mux.Handle("/debug/pprof/", auth.Handler(NewPprofHandler(m.Config.Pprof.MaxDuration)))
+	}
 	return mux
 }
 
-// NewExpHandler creates an http.Handler ready to expose all the collected metrics as a JSON
+// NewExpHandler creates an http.Handler ready to expose all the collected metrics as a JSON. Check
+// NewStatsHandler for the supported filters and formats
 func (m *Metrics) NewExpHandler() http.Handler {
-	return NewExpHandler(m.Registry)
+	return NewStatsHandler(m.Metrics)
 }
 
 // NewHTTPHandler wraps an http.Handler adding some simple instrumentation to the handler
@@ -90,18 +125,34 @@ func NewExpHandler(parent *metrics.Registry) http.Handler {
 func NewHTTPHandler(name string, h http.Handler, rm *krakendmetrics.RouterMetrics) http.HandlerFunc {
 	rm.RegisterResponseWriterMetrics(name)
 	return func(w http.ResponseWriter, r *http.Request) {
+		markMatched(r)
 		rm.Connection(r.TLS)
 		rw := newHTTPResponseWriter(name, w, rm)
-		h.ServeHTTP(rw, r)
-		rw.end()
-		rm.Disconnection()
+		rw.consumer = rm.Consumer(r)
+		rm.HeavyHitters().Record(r)
+		defer func() {
+			v := recover()
+			if v != nil && rw.firstByte.IsZero() {
+				// the router handler recovers the panic responding with a 500
+				rw.status = http.StatusInternalServerError
+			}
+			rw.end()
+			if !rw.hijacked {
+				rm.SlowResponse(name, r, rw.status, rm.Now().Sub(rw.begin))
+			}
+			rm.Disconnection()
+			if v != nil {
+				panic(v)
+			}
+		}()
+		h.ServeHTTP(exposeWriter(rw, w), r)
 	}
 }
 
 func newHTTPResponseWriter(name string, rw http.ResponseWriter, rm *krakendmetrics.RouterMetrics) *responseWriter {
 	return &responseWriter{
 		ResponseWriter: rw,
-		begin:          time.Now(),
+		begin:          rm.Now(),
 		name:           name,
 		rm:             rm,
 		status:         200,
@@ -111,30 +162,45 @@ func newHTTPResponseWriter(name string, rw http.ResponseWriter, rm *krakendmetri
 type responseWriter struct {
 	http.ResponseWriter
 	begin        time.Time
+	firstByte    time.Time
 	name         string
+	consumer     string
 	rm           *krakendmetrics.RouterMetrics
 	responseSize int
 	status       int
+	flushes      int
+	hijacked     bool
+	zeroCopy     bool
 }
 
 // WriteHeader implementes the http.ResponseWriter interface
 func (w *responseWriter) WriteHeader(code int) {
+	w.markFirstByte()
 	w.ResponseWriter.WriteHeader(code)
 	w.status = code
 }
 
 // Write implementes the http.ResponseWriter interface
 func (w *responseWriter) Write(data []byte) (i int, err error) {
+	w.markFirstByte()
 	i, err = w.ResponseWriter.Write(data)
 	w.responseSize += i
 	return
 }
 
+func (w *responseWriter) markFirstByte() {
+	if w.firstByte.IsZero() {
+		w.firstByte = w.rm.Now()
+	}
+}
+
 var _ http.Flusher = (*responseWriter)(nil)
 
 // Flush implements the http.Flush interface
 func (w *responseWriter) Flush() {
 	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.markFirstByte()
+		w.flushes++
 		f.Flush()
 	}
 }
@@ -143,15 +209,68 @@ var _ http.Hijacker = (*responseWriter)(nil)
 
 // Hijack implements the http.Hijacker interface
 func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
-	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
-		return h.Hijack()
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("not supported")
+	}
+	conn, buf, err := h.Hijack()
+	if err != nil {
+		return conn, buf, err
+	}
+	w.hijacked = true
+	conn = w.rm.Hijacked(w.name, conn)
+	// the buffered reader and writer must go through the instrumented connection. The buffers holding
+	// data are kept, since resetting them would lose it
+	if buf != nil && buf.Reader.Buffered() == 0 {
+		buf.Reader.Reset(conn)
 	}
-	return nil, nil, errors.New("not supported")
+	if buf != nil && buf.Writer.Buffered() == 0 {
+		buf.Writer.Reset(conn)
+	}
+	return conn, buf, nil
+}
+
+var _ http.Pusher = (*responseWriter)(nil)
+
+// Push implements the http.Pusher interface
+func (w *responseWriter) Push(target string, opts *http.PushOptions) error {
+	if p, ok := w.ResponseWriter.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+var _ io.ReaderFrom = (*responseWriter)(nil)
+
+// ReadFrom implements the io.ReaderFrom interface, keeping the zero-copy path (sendfile) of the wrapped
+// writer when available. Only the sources it can send without copying them are recorded as zero-copy
+func (w *responseWriter) ReadFrom(src io.Reader) (n int64, err error) {
+	w.markFirstByte()
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		w.zeroCopy = isZeroCopySource(src)
+		n, err = rf.ReadFrom(src)
+		w.responseSize += int(n)
+		return
+	}
+	return io.Copy(writerOnly{w}, src)
+}
+
+// Unwrap returns the wrapped http.ResponseWriter, as expected by http.ResponseController
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
 }
 
 func (w *responseWriter) end() {
-	duration := time.Since(w.begin)
+	if w.hijacked {
+		return
+	}
+	if w.zeroCopy {
+		w.rm.Counter("response", w.name, "zero-copy", "count").Inc(1)
+	}
+	now := w.rm.Now()
 	w.rm.Counter("response", w.name, "status", strconv.Itoa(w.status), "count").Inc(1)
 	w.rm.Histogram("response", w.name, "size").Update(int64(w.responseSize))
-	w.rm.Histogram("response", w.name, "time").Update(int64(duration))
+	w.rm.Histogram("response", w.name, "time").Update(int64(now.Sub(w.begin)))
+	w.rm.ResponseStreamed(w.name, w.begin, w.firstByte, now, w.flushes, int64(w.responseSize))
+	w.rm.ConsumerResponse(w.consumer, w.status, int64(w.responseSize), now.Sub(w.begin))
 }
@@ -1,12 +1,14 @@
 package mux
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"math/rand"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
@@ -149,6 +151,7 @@ func TestNewHTTPHandler(t *testing.T) {
 		"router.response.test.status.200.count": {},
 		"router.response.test.time":             {},
 		"router.response.test.size":             {},
+		"router.response.test.ttfb":             {},
 		"router.response.test.status":           {},
 	}
 	tracked := make([]string, 0, len(expected))
@@ -177,6 +180,152 @@ func TestNewHTTPHandler(t *testing.T) {
 	ts.Close()
 }
 
+func TestNewHTTPHandler_streaming(t *testing.T) {
+	registry := metrics.NewRegistry()
+
+	rm := krakendmetrics.NewRouterMetrics(&registry)
+	assertion := func(w http.ResponseWriter, _ *http.Request) {
+		time.Sleep(time.Millisecond)
+		w.WriteHeader(200)
+		for i := 0; i < 3; i++ {
+			w.Write([]byte("chunk"))
+			w.(http.Flusher).Flush()
+			time.Sleep(time.Millisecond)
+		}
+	}
+	h := NewHTTPHandler("test", http.HandlerFunc(assertion), rm)
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "chunkchunkchunk" {
+		t.Errorf("unexpected body: %s", string(body))
+	}
+
+	ttfb := registry.Get("router.response.test.ttfb").(metrics.Histogram)
+	if ttfb.Count() != 1 || ttfb.Max() < int64(time.Millisecond) {
+		t.Errorf("unexpected ttfb. count: %d, max: %d", ttfb.Count(), ttfb.Max())
+	}
+	if h := registry.Get("router.response.test.stream.flushes").(metrics.Histogram); h.Max() != 3 {
+		t.Errorf("unexpected number of flushes: %d", h.Max())
+	}
+	if h := registry.Get("router.response.test.stream.size").(metrics.Histogram); h.Max() != 15 {
+		t.Errorf("unexpected streamed size: %d", h.Max())
+	}
+	if h := registry.Get("router.response.test.stream.time").(metrics.Histogram); h.Max() < int64(2*time.Millisecond) {
+		t.Errorf("unexpected streaming time: %d", h.Max())
+	}
+}
+
+func TestNewHTTPHandler_hijacked(t *testing.T) {
+	registry := metrics.NewRegistry()
+
+	rm := krakendmetrics.NewRouterMetrics(&registry)
+	assertion := func(w http.ResponseWriter, _ *http.Request) {
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n")
+		buf.Flush()
+		conn.Close()
+	}
+	h := NewHTTPHandler("test", http.HandlerFunc(assertion), rm)
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	req, _ := http.NewRequest("GET", ts.URL, http.NoBody)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "test")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	if v := registry.Get("router.hijacked.test.count").(metrics.Counter).Count(); v != 1 {
+		t.Errorf("unexpected number of upgrades: %d", v)
+	}
+	if v := registry.Get("router.hijacked-gauge").(metrics.Gauge).Value(); v != 0 {
+		t.Errorf("unexpected number of active connections: %d", v)
+	}
+	if h := registry.Get("router.hijacked.test.out").(metrics.Histogram); h.Count() != 1 || h.Max() == 0 {
+		t.Errorf("unexpected bytes out. count: %d, max: %d", h.Count(), h.Max())
+	}
+	if h := registry.Get("router.response.test.time").(metrics.Histogram); h.Count() != 0 {
+		t.Errorf("hijacked connections should not be recorded as responses: %d", h.Count())
+	}
+	if v := registry.Get("router.response.test.status.200.count"); v != nil {
+		t.Error("hijacked connections should not be recorded as responses")
+	}
+}
+
+func TestNewHTTPHandler_hijackedRead(t *testing.T) {
+	registry := metrics.NewRegistry()
+
+	rm := krakendmetrics.NewRouterMetrics(&registry)
+	done := make(chan struct{})
+	assertion := func(w http.ResponseWriter, _ *http.Request) {
+		defer close(done)
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n")
+		buf.Flush()
+		line, err := buf.ReadString('\n')
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		buf.WriteString("pong:" + line)
+		buf.Flush()
+	}
+	h := NewHTTPHandler("test", http.HandlerFunc(assertion), rm)
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: %s\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n", ts.Listener.Addr())
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	conn.Write([]byte("ping\n"))
+	if line, _ := r.ReadString('\n'); line != "pong:ping\n" {
+		t.Errorf("unexpected answer: %q", line)
+	}
+	<-done
+
+	if h := registry.Get("router.hijacked.test.in").(metrics.Histogram); h.Count() != 1 || h.Max() != 5 {
+		t.Errorf("unexpected bytes in. count: %d, max: %d", h.Count(), h.Max())
+	}
+	if h := registry.Get("router.hijacked.test.out").(metrics.Histogram); h.Count() != 1 || h.Max() != 82 {
+		t.Errorf("unexpected bytes out. count: %d, max: %d", h.Count(), h.Max())
+	}
+}
+
 func TestDisabledMetricMethods(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -197,6 +346,48 @@ func TestDisabledMetricMethods(t *testing.T) {
 	}
 }
 
+func TestNewEngine_auth(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	buf := bytes.NewBuffer(make([]byte, 1024))
+	l, _ := logging.NewLogger("DEBUG", buf, "")
+	cfg := map[string]interface{}{krakendmetrics.Namespace: map[string]interface{}{
+		"endpoint_disabled": true,
+		"auth": map[string]interface{}{
+			"allowed_cidrs": []interface{}{"127.0.0.0/8"},
+		},
+	}}
+	engine := New(ctx, cfg, l).NewEngine()
+
+	for remoteAddr, want := range map[string]int{
+		"127.0.0.1:1234": http.StatusOK,
+		"10.0.0.1:1234":  http.StatusForbidden,
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/__stats", http.NoBody)
+		req.RemoteAddr = remoteAddr
+		engine.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Errorf("%s: unexpected status code. have: %d, want: %d", remoteAddr, w.Code, want)
+		}
+	}
+
+	cfg = map[string]interface{}{krakendmetrics.Namespace: map[string]interface{}{
+		"endpoint_disabled": true,
+		"auth": map[string]interface{}{
+			"tokens_file": "/unknown/file",
+		},
+	}}
+	engine = New(ctx, cfg, l).NewEngine()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/__stats", http.NoBody)
+	req.RemoteAddr = "127.0.0.1:1234"
+	engine.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("an invalid auth config should deny every request. status: %d", w.Code)
+	}
+}
+
 func TestStatsEndpoint(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -227,3 +418,85 @@ func TestStatsEndpoint(t *testing.T) {
 		return
 	}
 }
+
+func TestNewFromServiceConfig(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l, _ := logging.NewLogger("DEBUG", new(bytes.Buffer), "")
+	cfg := config.ServiceConfig{
+		Endpoints:   []*config.EndpointConfig{{Endpoint: "/a", Backend: []*config.Backend{{URLPattern: "/a"}}}},
+		ExtraConfig: config.ExtraConfig{krakendmetrics.Namespace: map[string]interface{}{"endpoint_disabled": true}},
+	}
+	metric := NewFromServiceConfig(ctx, cfg, l)
+	if v := metric.TakeSnapshot().Gauges["krakend.service.config.backends"]; v != 1 {
+		t.Errorf("unexpected number of backends: %d", v)
+	}
+}
+
+func TestNewHTTPHandler_consumers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l, _ := logging.NewLogger("DEBUG", new(bytes.Buffer), "")
+	metric := New(ctx, map[string]interface{}{krakendmetrics.Namespace: map[string]interface{}{
+		"endpoint_disabled": true,
+		"consumers":         map[string]interface{}{"header": "X-Api-Key", "hash": false},
+	}}, l)
+
+	h := metric.NewHTTPHandler("/consumer", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("fail") != "" {
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+		w.Write([]byte("hello"))
+	}))
+	for _, target := range []string{"/consumer", "/consumer", "/consumer?fail=1"} {
+		req := httptest.NewRequest(http.MethodGet, target, http.NoBody)
+		req.Header.Set("X-Api-Key", "acme")
+		h(httptest.NewRecorder(), req)
+	}
+	h(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/consumer", http.NoBody))
+
+	snapshot := metric.TakeSnapshot()
+	for name, want := range map[string]int64{
+		"krakend.router.consumer.acme.requests":        3,
+		"krakend.router.consumer.acme.errors":          1,
+		"krakend.router.consumer.acme.bytes":           15,
+		"krakend.router.consumer.__anonymous.requests": 1,
+	} {
+		if v := snapshot.Counters[name]; v != want {
+			t.Errorf("%s: want %d, have %d", name, want, v)
+		}
+	}
+	if v := snapshot.Histograms["krakend.router.consumer.acme.time"]; v.Max == 0 {
+		t.Errorf("unexpected response times: %+v", v)
+	}
+}
+
+func TestNewHTTPHandler_slowRequests(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	buf := new(bytes.Buffer)
+	l, _ := logging.NewLogger("DEBUG", buf, "")
+	metric := New(ctx, map[string]interface{}{krakendmetrics.Namespace: map[string]interface{}{
+		"endpoint_disabled": true,
+		"slow_requests":     map[string]interface{}{"thresholds": map[string]interface{}{"router": "5ms"}},
+	}}, l)
+
+	h := metric.NewHTTPHandler("/slow", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("wait") != "" {
+			time.Sleep(10 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	for _, target := range []string{"/slow", "/slow?wait=1"} {
+		req := httptest.NewRequest(http.MethodGet, target, http.NoBody)
+		req.Header.Set("X-Request-Id", target)
+		h(httptest.NewRecorder(), req)
+	}
+
+	if !strings.Contains(buf.String(), "layer=router name=/slow path=/slow") || !strings.Contains(buf.String(), "status=202 request_id=/slow?wait=1") {
+		t.Errorf("slow request not logged: %s", buf.String())
+	}
+	if e := metric.Exemplars()["krakend.router.response./slow.time"]; len(e) != 1 || e[0].RequestID != "/slow?wait=1" {
+		t.Errorf("unexpected exemplars: %+v", e)
+	}
+}
@@ -0,0 +1,158 @@
+package mux
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NewPprofHandler creates an http.Handler serving the profiling routes of net/http/pprof under
+// /debug/pprof/, including the runtime execution traces. Requests for profiles or traces longer than
+// maxDuration or with a non positive duration are rejected, and the ones without an explicit duration
+// are capped to it. A maxDuration under a second is raised to a second. The delta profiles (named
+// profiles with a duration) are not supported.
+//
+// The handlers are implemented on top of runtime/pprof and runtime/trace, since importing
+// net/http/pprof registers its routes on http.DefaultServeMux, out of the access control rules of the
+// stats server
+func NewPprofHandler(maxDuration time.Duration) http.Handler {
+	maxDuration = max(maxDuration, time.Second)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/debug/pprof/")
+		var duration time.Duration
+		// only the traces accept fractional durations
+		fractional := false
+		switch name {
+		case "profile":
+			duration = 30 * time.Second
+		case "trace":
+			duration = time.Second
+			fractional = true
+		}
+
+		if v := r.URL.Query().Get("seconds"); v != "" {
+			if duration == 0 {
+				http.Error(w, "the delta profiles are not supported", http.StatusBadRequest)
+				return
+			}
+			seconds, err := strconv.ParseFloat(v, 64)
+			if err != nil || seconds <= 0 || (!fractional && seconds != math.Trunc(seconds)) ||
+				seconds > maxDuration.Seconds() {
+				http.Error(w, "the profile duration must be a positive number of seconds up to "+maxDuration.String(), http.StatusBadRequest)
+				return
+			}
+			duration = time.Duration(seconds * float64(time.Second))
+		}
+		duration = min(duration, maxDuration)
+
+		switch name {
+		case "":
+			pprofIndex(w)
+		case "cmdline":
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			io.WriteString(w, strings.Join(os.Args, "\x00"))
+		case "symbol":
+			pprofSymbol(w, r)
+		case "profile":
+			pprofRecord(w, r, "profile", duration, pprof.StartCPUProfile, pprof.StopCPUProfile)
+		case "trace":
+			pprofRecord(w, r, "trace", duration, trace.Start, trace.Stop)
+		default:
+			pprofProfile(w, r, name)
+		}
+	})
+}
+
+// pprofIndex lists the available profiles
+func pprofIndex(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	var b bytes.Buffer
+	b.WriteString("<html><head><title>/debug/pprof/</title></head><body><p>Profiles:</p><ul>\n")
+	for _, p := range pprof.Profiles() {
+		name := html.EscapeString(p.Name())
+		fmt.Fprintf(&b, "<li>%d <a href=\"%s?debug=1\">%s</a></li>\n", p.Count(), name, name)
+	}
+	b.WriteString("<li><a href=\"profile\">profile</a> (CPU profile)</li>\n")
+	b.WriteString("<li><a href=\"trace\">trace</a> (execution trace)</li>\n")
+	b.WriteString("<li><a href=\"cmdline\">cmdline</a></li>\n")
+	b.WriteString("</ul></body></html>\n")
+	w.Write(b.Bytes())
+}
+
+// pprofProfile writes the named profile, in the binary format or as text if the debug param is set
+func pprofProfile(w http.ResponseWriter, r *http.Request, name string) {
+	p := pprof.Lookup(name)
+	if p == nil {
+		http.Error(w, "unknown profile", http.StatusNotFound)
+		return
+	}
+	debug, _ := strconv.Atoi(r.URL.Query().Get("debug"))
+	if name == "heap" && r.URL.Query().Get("gc") != "" {
+		runtime.GC()
+	}
+	if debug != 0 {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	}
+	p.WriteTo(w, debug)
+}
+
+// pprofRecord records the CPU profile or the execution trace during the duration, or until the client
+// leaves
+func pprofRecord(w http.ResponseWriter, r *http.Request, name string, duration time.Duration, start func(io.Writer) error, stop func()) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	if err := start(w); err != nil {
+		w.Header().Del("Content-Disposition")
+		http.Error(w, "unable to start the "+name+": "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	select {
+	case <-time.After(duration):
+	case <-r.Context().Done():
+	}
+	stop()
+}
+
+// pprofSymbol resolves the program counters of the query or the body (separated by +) to the names of
+// their functions, as expected by the pprof tool
+func pprofSymbol(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	var b bytes.Buffer
+	// the tool only checks that the symbols are available
+	b.WriteString("num_symbols: 1\n")
+
+	var src io.Reader = strings.NewReader(r.URL.RawQuery)
+	if r.Method == http.MethodPost {
+		src = r.Body
+	}
+	in := bufio.NewReader(src)
+	for {
+		word, err := in.ReadSlice('+')
+		if err == nil {
+			word = word[:len(word)-1]
+		}
+		if pc, _ := strconv.ParseUint(string(word), 0, 64); pc != 0 {
+			if f := runtime.FuncForPC(uintptr(pc)); f != nil {
+				fmt.Fprintf(&b, "%#x %s\n", pc, f.Name())
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	w.Write(b.Bytes())
+}
@@ -0,0 +1,91 @@
+package mux
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewPprofHandler(t *testing.T) {
+	h := NewPprofHandler(10 * time.Second)
+
+	for path, want := range map[string]int{
+		"/debug/pprof/":                   http.StatusOK,
+		"/debug/pprof/cmdline":            http.StatusOK,
+		"/debug/pprof/heap":               http.StatusOK,
+		"/debug/pprof/goroutine?debug=1":  http.StatusOK,
+		"/debug/pprof/unknown":            http.StatusNotFound,
+		"/debug/pprof/allocs?seconds=1":   http.StatusBadRequest,
+		"/debug/pprof/profile?seconds=60": http.StatusBadRequest,
+		"/debug/pprof/trace?seconds=abc":  http.StatusBadRequest,
+		"/debug/pprof/allocs?seconds=11":  http.StatusBadRequest,
+		"/debug/pprof/profile?seconds=0":  http.StatusBadRequest,
+		"/debug/pprof/profile?seconds=-1": http.StatusBadRequest,
+		"/debug/pprof/profile?seconds=.5": http.StatusBadRequest,
+		"/debug/pprof/trace?seconds=0":    http.StatusBadRequest,
+		"/debug/pprof/trace?seconds=10.5": http.StatusBadRequest,
+		"/debug/pprof/trace?seconds=0.01": http.StatusOK,
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, http.NoBody)
+		h.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Errorf("%s: unexpected status code. have: %d, want: %d", path, w.Code, want)
+		}
+	}
+}
+
+func TestNewPprofHandler_capped(t *testing.T) {
+	h := NewPprofHandler(time.Second)
+
+	begin := time.Now()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/debug/pprof/profile", http.NoBody)
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("unexpected status code: %d", w.Code)
+	}
+	if d := time.Since(begin); d > 5*time.Second {
+		t.Errorf("the profile duration should be capped. took: %s", d)
+	}
+}
+
+func TestNewPprofHandler_subsecond(t *testing.T) {
+	h := NewPprofHandler(100 * time.Millisecond)
+
+	begin := time.Now()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/debug/pprof/trace", http.NoBody)
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("unexpected status code: %d", w.Code)
+	}
+	if d := time.Since(begin); d > 5*time.Second {
+		t.Errorf("the trace duration should be capped. took: %s", d)
+	}
+}
+
+func TestNewPprofHandler_symbol(t *testing.T) {
+	h := NewPprofHandler(time.Second)
+	pc := reflect.ValueOf(TestNewPprofHandler_symbol).Pointer()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/debug/pprof/symbol", strings.NewReader(fmt.Sprintf("%#x+0x0", pc)))
+	h.ServeHTTP(w, req)
+	want := fmt.Sprintf("num_symbols: 1\n%#x github.com/krakend/krakend-metrics/v2/mux.TestNewPprofHandler_symbol\n", pc)
+	if body := w.Body.String(); body != want {
+		t.Errorf("unexpected symbols: %q", body)
+	}
+}
+
+func TestNewPprofHandler_defaultServeMux(t *testing.T) {
+	// the profiling routes must only be reachable through the stats server
+	req, _ := http.NewRequest("GET", "/debug/pprof/cmdline", http.NoBody)
+	if _, pattern := http.DefaultServeMux.Handler(req); pattern != "" {
+		t.Errorf("the default mux serves the profiling routes: %s", pattern)
+	}
+}
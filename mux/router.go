@@ -0,0 +1,128 @@
+package mux
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+
+	krakendmetrics "github.com/krakend/krakend-metrics/v2"
+)
+
+// NewRouterHandler wraps the router handler so the requests not matching any route, the ones rejected
+// because of their method and the recovered panics are also instrumented
+func (m *Metrics) NewRouterHandler(h http.Handler) http.Handler {
+	if m.Config == nil || m.Config.RouterDisabled {
+		return h
+	}
+	return NewRouterHandler(h, m.Router)
+}
+
+// NewRouterHandler wraps the router handler so the requests not matching any route, the ones rejected
+// because of their method and the recovered panics are also instrumented. Requests served by handlers
+// created with NewHTTPHandler are not recorded again.
+func NewRouterHandler(h http.Handler, rm *krakendmetrics.RouterMetrics) http.Handler {
+	rm.RegisterUnmatchedMetrics()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		matched := new(bool)
+		rw := &routerResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		begin := rm.Now()
+
+		defer func() {
+			if v := recover(); v != nil {
+				rm.Panic(v)
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+				if !rw.written {
+					rw.WriteHeader(http.StatusInternalServerError)
+				}
+				return
+			}
+			if !*matched && !rw.hijacked {
+				rm.Unmatched(rw.status, int64(rw.size), rm.Now().Sub(begin))
+				rm.HeavyHitters().Record(r)
+			}
+		}()
+
+		h.ServeHTTP(exposeWriter(rw, w), r.WithContext(context.WithValue(r.Context(), matchedKey{}, matched)))
+	})
+}
+
+type matchedKey struct{}
+
+// markMatched flags the request as served by an instrumented endpoint handler
+func markMatched(r *http.Request) {
+	if matched, ok := r.Context().Value(matchedKey{}).(*bool); ok {
+		*matched = true
+	}
+}
+
+type routerResponseWriter struct {
+	http.ResponseWriter
+	status   int
+	size     int
+	written  bool
+	hijacked bool
+}
+
+// WriteHeader implements the http.ResponseWriter interface
+func (w *routerResponseWriter) WriteHeader(code int) {
+	w.ResponseWriter.WriteHeader(code)
+	if !w.written {
+		w.status = code
+		w.written = true
+	}
+}
+
+// Write implements the http.ResponseWriter interface
+func (w *routerResponseWriter) Write(data []byte) (int, error) {
+	w.written = true
+	n, err := w.ResponseWriter.Write(data)
+	w.size += n
+	return n, err
+}
+
+// Flush implements the http.Flusher interface
+func (w *routerResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.written = true
+		f.Flush()
+	}
+}
+
+// Hijack implements the http.Hijacker interface
+func (w *routerResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("not supported")
+	}
+	w.hijacked = true
+	return h.Hijack()
+}
+
+// Push implements the http.Pusher interface
+func (w *routerResponseWriter) Push(target string, opts *http.PushOptions) error {
+	if p, ok := w.ResponseWriter.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+// ReadFrom implements the io.ReaderFrom interface
+func (w *routerResponseWriter) ReadFrom(src io.Reader) (int64, error) {
+	w.written = true
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		n, err := rf.ReadFrom(src)
+		w.size += int(n)
+		return n, err
+	}
+	return io.Copy(writerOnly{w}, src)
+}
+
+// Unwrap returns the wrapped http.ResponseWriter, as expected by http.ResponseController
+func (w *routerResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
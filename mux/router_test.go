@@ -0,0 +1,70 @@
+package mux
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	krakendmetrics "github.com/krakend/krakend-metrics/v2"
+	metrics "github.com/rcrowley/go-metrics"
+)
+
+func TestNewRouterHandler(t *testing.T) {
+	registry := metrics.NewRegistry()
+	rm := krakendmetrics.NewRouterMetrics(&registry)
+
+	router := http.NewServeMux()
+	router.Handle("GET /test", NewHTTPHandler("/test", http.HandlerFunc(dummyHTTPHandler), rm))
+	router.Handle("GET /missing", NewHTTPHandler("/missing", http.NotFoundHandler(), rm))
+	router.HandleFunc("/panic", func(_ http.ResponseWriter, _ *http.Request) { panic(errors.New("boom")) })
+	router.Handle("/instrumented-panic", NewHTTPHandler("/instrumented-panic", http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+		panic(errors.New("boom"))
+	}), rm))
+	h := NewRouterHandler(router, rm)
+
+	for _, tc := range []struct {
+		method string
+		path   string
+		status int
+	}{
+		{"GET", "/test", 200},
+		{"GET", "/missing", 404},
+		{"GET", "/unknown", 404},
+		{"GET", "/unknown", 404},
+		{"POST", "/test", 405},
+		{"GET", "/panic", 500},
+		{"GET", "/instrumented-panic", 500},
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(tc.method, tc.path, http.NoBody)
+		h.ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Errorf("%s %s: unexpected status code: %d", tc.method, tc.path, w.Code)
+		}
+	}
+
+	for k, want := range map[string]int64{
+		"router.response./test.status.200.count":                1,
+		"router.response./missing.status.404.count":             1,
+		"router.response.__no_route.status.404.count":           2,
+		"router.response.__method_not_allowed.status.405.count": 1,
+		"router.response./instrumented-panic.status.500.count":  1,
+		"router.panic.errors_errorString.count":                 2,
+		// the panics of the instrumented routes still close their connections
+		"router.connected":    3,
+		"router.disconnected": 3,
+	} {
+		c, ok := registry.Get(k).(metrics.Counter)
+		if !ok {
+			t.Errorf("counter %s not registered", k)
+			continue
+		}
+		if c.Count() != want {
+			t.Errorf("unexpected value for %s. have: %d, want: %d", k, c.Count(), want)
+		}
+	}
+	if h := registry.Get("router.response.__no_route.size").(metrics.Histogram); h.Count() != 2 || h.Max() == 0 {
+		t.Errorf("unexpected unmatched response sizes. count: %d, max: %d", h.Count(), h.Max())
+	}
+}
@@ -0,0 +1,224 @@
+package mux
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	krakendmetrics "github.com/krakend/krakend-metrics/v2"
+	"github.com/rcrowley/go-metrics"
+)
+
+const (
+	// SnapshotContentType is the media type of the latest snapshot (see krakendmetrics.Stats) in JSON
+	SnapshotContentType = "application/vnd.krakend.stats+json"
+	// PrometheusContentType is the media type of the Prometheus text exposition format
+	PrometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+	// CSVContentType is the media type of the CSV dump of the metrics
+	CSVContentType = "text/csv; charset=utf-8"
+	// OpenMetricsContentType is the media type of the OpenMetrics text format
+	OpenMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+// NewStatsHandler creates an http.Handler exposing the collected metrics. The format is selected with the
+// Accept header: the expvar JSON (application/json, the default), the latest snapshot as JSON
+// (application/vnd.krakend.stats+json), the Prometheus text format (text/plain), the OpenMetrics text
+// format with the slow request exemplars (application/openmetrics-text) or CSV (text/csv).
+// The metrics can be filtered with the query params prefix, match (a glob) and type, and the response is
+// compressed if the client accepts gzip
+func NewStatsHandler(m *krakendmetrics.Metrics) http.Handler {
+	expHandler := NewExpHandler(m.Registry)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filter, err := krakendmetrics.ParseFilter(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Header().Add("Vary", "Accept-Encoding")
+			gw := gzip.NewWriter(w)
+			defer gw.Close()
+			w = &gzipResponseWriter{ResponseWriter: w, Writer: gw}
+		}
+		w.Header().Add("Vary", "Accept")
+
+		switch negotiate(r.Header.Get("Accept")) {
+		case SnapshotContentType:
+			w.Header().Set("Content-Type", SnapshotContentType)
+			json.NewEncoder(w).Encode(m.Snapshot().Filter(filter))
+		case PrometheusContentType:
+			w.Header().Set("Content-Type", PrometheusContentType)
+			krakendmetrics.WritePrometheus(w, *m.Registry, filter)
+		case OpenMetricsContentType:
+			w.Header().Set("Content-Type", OpenMetricsContentType)
+			krakendmetrics.WriteOpenMetrics(w, *m.Registry, filter, m.Exemplars())
+		case CSVContentType:
+			w.Header().Set("Content-Type", CSVContentType)
+			krakendmetrics.WriteCSV(w, *m.Registry, filter)
+		default:
+			if filter.IsEmpty() {
+				expHandler.ServeHTTP(w, r)
+				return
+			}
+			serveFilteredExp(w, r, expHandler, *m.Registry, filter)
+		}
+	})
+}
+
+type mediaRange struct {
+	contentType string
+	q           float64
+}
+
+// parseMediaRanges returns the values of an Accept or Accept-Encoding header with a positive quality,
+// sorted by quality
+func parseMediaRanges(header string) []mediaRange {
+	ranges := []mediaRange{}
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		mr := mediaRange{contentType: strings.ToLower(strings.TrimSpace(params[0])), q: 1}
+		for _, p := range params[1:] {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(p), "q="); ok {
+				if q, err := strconv.ParseFloat(v, 64); err == nil {
+					mr.q = q
+				}
+			}
+		}
+		if mr.q > 0 {
+			ranges = append(ranges, mr)
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+	return ranges
+}
+
+// acceptsGzip returns whether the Accept-Encoding header accepts gzip, either explicitly or with the
+// wildcard, and does not reject it with a zero quality
+func acceptsGzip(acceptEncoding string) bool {
+	wildcard := false
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding := strings.ToLower(strings.TrimSpace(strings.Split(part, ";")[0]))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		accepted := len(parseMediaRanges(part)) > 0
+		if coding == "gzip" {
+			return accepted
+		}
+		wildcard = accepted
+	}
+	return wildcard
+}
+
+// negotiate returns the supported content type preferred by the Accept header
+func negotiate(accept string) string {
+	for _, mr := range parseMediaRanges(accept) {
+		switch mr.contentType {
+		case "application/json", "*/*", "application/*":
+			return "application/json"
+		case SnapshotContentType:
+			return SnapshotContentType
+		case "text/plain", "text/*":
+			return PrometheusContentType
+		case "text/csv":
+			return CSVContentType
+		case "application/openmetrics-text":
+			return OpenMetricsContentType
+		}
+	}
+	return "application/json"
+}
+
+// serveFilteredExp runs the expvar handler and keeps only the variables of the metrics passing the filter
+// and, if no type is required, the non metric variables (cmdline, memstats...) passing the name filters
+func serveFilteredExp(w http.ResponseWriter, r *http.Request, h http.Handler, registry metrics.Registry, f krakendmetrics.Filter) {
+	rec := &bufferedResponseWriter{header: http.Header{}}
+	h.ServeHTTP(rec, r)
+
+	vars := map[string]json.RawMessage{}
+	if err := json.Unmarshal(rec.body.Bytes(), &vars); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	allowed := map[string]bool{}
+	fromRegistry := map[string]bool{}
+	registry.Each(func(name string, v interface{}) {
+		ok := f.Allows(name, krakendmetrics.MetricType(v))
+		for _, k := range expKeys(name, v) {
+			fromRegistry[k] = true
+			allowed[k] = ok
+		}
+	})
+
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		if allowed[k] || (!fromRegistry[k] && len(f.Types) == 0 && f.AllowsName(k)) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	io.WriteString(w, "{\n")
+	for i, k := range keys {
+		if i > 0 {
+			io.WriteString(w, ",\n")
+		}
+		fmt.Fprintf(w, "%q: %s", k, vars[k])
+	}
+	io.WriteString(w, "\n}\n")
+}
+
+var (
+	sampleExpSuffixes = []string{".count", ".min", ".max", ".mean", ".std-dev", ".50-percentile", ".75-percentile",
+		".95-percentile", ".99-percentile", ".999-percentile"}
+	rateExpSuffixes = []string{".one-minute", ".five-minute", ".fifteen-minute"}
+)
+
+// expKeys returns the names of the expvar variables published by the exp package for the metric
+func expKeys(name string, v interface{}) []string {
+	var suffixes []string
+	switch v.(type) {
+	case metrics.Histogram:
+		suffixes = sampleExpSuffixes
+	case metrics.Meter:
+		suffixes = append([]string{".count", ".mean"}, rateExpSuffixes...)
+	case metrics.Timer:
+		suffixes = append(append(append([]string{}, sampleExpSuffixes...), rateExpSuffixes...), ".mean-rate")
+	default:
+		return []string{name}
+	}
+	res := make([]string, len(suffixes))
+	for i, s := range suffixes {
+		res[i] = name + s
+	}
+	return res
+}
+
+type bufferedResponseWriter struct {
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponseWriter) Header() http.Header         { return b.header }
+func (b *bufferedResponseWriter) Write(p []byte) (int, error) { return b.body.Write(p) }
+func (*bufferedResponseWriter) WriteHeader(_ int)             {}
+
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	io.Writer
+}
+
+// Write implements the http.ResponseWriter interface
+func (g *gzipResponseWriter) Write(p []byte) (int, error) {
+	return g.Writer.Write(p)
+}
@@ -0,0 +1,118 @@
+package mux
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	krakendmetrics "github.com/krakend/krakend-metrics/v2"
+	metrics "github.com/rcrowley/go-metrics"
+)
+
+func TestNewStatsHandler(t *testing.T) {
+	registry := metrics.NewPrefixedRegistry("krakend.")
+	rm := krakendmetrics.NewRouterMetrics(&registry)
+	rm.Counter("response", "/test", "status", "200", "count").Inc(5)
+	rm.Histogram("response", "/test", "time").Update(100)
+	m := &krakendmetrics.Metrics{Registry: &registry, Router: rm}
+	h := NewStatsHandler(m)
+
+	get := func(query, accept, encoding string) *http.Response {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/__stats"+query, http.NoBody)
+		req.Header.Set("Accept", accept)
+		req.Header.Set("Accept-Encoding", encoding)
+		h.ServeHTTP(w, req)
+		return w.Result()
+	}
+	body := func(resp *http.Response) string {
+		b, _ := io.ReadAll(resp.Body)
+		return string(b)
+	}
+
+	resp := get("", "*/*", "")
+	var exp map[string]interface{}
+	if err := json.Unmarshal([]byte(body(resp)), &exp); err != nil {
+		t.Error(err)
+	}
+	if _, ok := exp["cmdline"]; !ok {
+		t.Error("the unfiltered exp response should contain the cmdline")
+	}
+
+	resp = get("?prefix=krakend.router.response./test&type=histogram", "application/json", "")
+	exp = map[string]interface{}{}
+	if err := json.Unmarshal([]byte(body(resp)), &exp); err != nil {
+		t.Error(err)
+	}
+	if _, ok := exp["krakend.router.response./test.time.99-percentile"]; !ok {
+		t.Errorf("histogram not found: %v", exp)
+	}
+	for k := range exp {
+		if !strings.HasPrefix(k, "krakend.router.response./test.time.") {
+			t.Errorf("unexpected key: %s", k)
+		}
+	}
+
+	resp = get("?match=*.status.*", "text/plain;version=0.0.4;q=0.5,application/x-unknown", "")
+	if ct := resp.Header.Get("Content-Type"); ct != PrometheusContentType {
+		t.Errorf("unexpected content type: %s", ct)
+	}
+	if b := body(resp); !strings.Contains(b, "krakend_router_response__test_status_200_count 5\n") || strings.Contains(b, "connected") {
+		t.Errorf("unexpected prometheus output: %s", b)
+	}
+
+	resp = get("?prefix=krakend.router.response./test.time", "application/openmetrics-text;version=1.0.0,text/plain;q=0.5", "")
+	if ct := resp.Header.Get("Content-Type"); ct != OpenMetricsContentType {
+		t.Errorf("unexpected content type: %s", ct)
+	}
+	if b := body(resp); !strings.Contains(b, "# TYPE krakend_router_response__test_time summary\n") || !strings.HasSuffix(b, "# EOF\n") {
+		t.Errorf("unexpected openmetrics output: %s", b)
+	}
+
+	resp = get("?prefix=krakend.router.response", "text/csv", "gzip")
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Error("the response should be compressed")
+	}
+	gr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	csv, _ := io.ReadAll(gr)
+	if !strings.Contains(string(csv), "krakend.router.response./test.status.200.count,counter,count,5\n") {
+		t.Errorf("unexpected csv output: %s", csv)
+	}
+
+	resp = get("", SnapshotContentType, "")
+	var stats krakendmetrics.Stats
+	if err := json.Unmarshal([]byte(body(resp)), &stats); err != nil {
+		t.Error(err)
+	}
+
+	if resp := get("?type=unknown", "", ""); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	for header, want := range map[string]bool{
+		"":                       false,
+		"gzip":                   true,
+		"deflate, gzip;q=0.5":    true,
+		"gzip;q=0":               false,
+		"gzip;q=0.0, deflate":    false,
+		"*":                      true,
+		"*;q=0":                  false,
+		"gzip;q=0, *":            false,
+		"br, *;q=0.1":            true,
+		"x-gzip-but-not-really ": false,
+	} {
+		if have := acceptsGzip(header); have != want {
+			t.Errorf("%q: unexpected result. have: %v, want: %v", header, have, want)
+		}
+	}
+}
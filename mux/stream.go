@@ -0,0 +1,77 @@
+package mux
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	krakendmetrics "github.com/krakend/krakend-metrics/v2"
+)
+
+// NewStreamHandler creates an http.Handler pushing the snapshots as Server-Sent Events
+func (m *Metrics) NewStreamHandler() http.Handler {
+	return NewStreamHandler(m.Metrics)
+}
+
+// NewStreamHandler creates an http.Handler pushing the snapshots as Server-Sent Events. The stream starts
+// with the latest snapshot (a "snapshot" event) and then sends a new event after every collection tick.
+// With the query param mode=delta, the counters of the following events ("delta") hold their increment
+// since the previous one. The stream is closed if the client is too slow to keep up with the ticks
+func NewStreamHandler(m *krakendmetrics.Metrics) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming not supported", http.StatusInternalServerError)
+			return
+		}
+
+		snapshots, cancel := m.Subscribe()
+		defer cancel()
+
+		delta := r.URL.Query().Get("mode") == "delta"
+		event := "snapshot"
+		if delta {
+			event = "delta"
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+
+		previous := m.Snapshot()
+		if err := writeEvent(w, "snapshot", previous); err != nil {
+			return
+		}
+		flusher.Flush()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case s, ok := <-snapshots:
+				if !ok {
+					return
+				}
+				data := s
+				if delta {
+					data = s.Delta(previous)
+				}
+				previous = s
+				if err := writeEvent(w, event, data); err != nil {
+					return
+				}
+				flusher.Flush()
+			}
+		}
+	})
+}
+
+func writeEvent(w http.ResponseWriter, event string, s krakendmetrics.Stats) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
@@ -0,0 +1,76 @@
+package mux
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	krakendmetrics "github.com/krakend/krakend-metrics/v2"
+	"github.com/luraproject/lura/v2/logging"
+)
+
+func TestNewStreamHandler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	buf := bytes.NewBuffer(make([]byte, 1024))
+	l, _ := logging.NewLogger("DEBUG", buf, "")
+	cfg := map[string]interface{}{krakendmetrics.Namespace: map[string]interface{}{
+		"collection_time":   "50ms",
+		"endpoint_disabled": true,
+	}}
+	metric := New(ctx, cfg, l)
+
+	ts := httptest.NewServer(metric.NewStreamHandler())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "?mode=delta")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("unexpected content type: %s", ct)
+	}
+
+	events := []string{}
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
+	var event string
+	for len(events) < 3 && scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			var s krakendmetrics.Stats
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &s); err != nil {
+				t.Errorf("unexpected data: %s", line)
+			}
+			events = append(events, event)
+		}
+	}
+	if strings.Join(events, ",") != "snapshot,delta,delta" {
+		t.Errorf("unexpected events: %v", events)
+	}
+
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		for scanner.Scan() {
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("the stream should be closed after the context cancellation")
+	}
+}
@@ -0,0 +1,24 @@
+package mux
+
+import (
+	"net/http"
+
+	krakendmetrics "github.com/krakend/krakend-metrics/v2"
+)
+
+// NewTopHandler creates an http.Handler exposing the heavy hitters of the router
+func (m *Metrics) NewTopHandler() http.Handler {
+	return NewTopHandler(m.Router.HeavyHitters())
+}
+
+// NewTopHandler creates an http.Handler exposing the client IPs and the paths with more requests during
+// the last collection interval. It replies with a 404 Not Found if the tracker is nil
+func NewTopHandler(h *krakendmetrics.HeavyHitters) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if h == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"status": "heavy hitters not tracked"})
+			return
+		}
+		writeJSON(w, http.StatusOK, h.Report())
+	})
+}
@@ -0,0 +1,54 @@
+package mux
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	krakendmetrics "github.com/krakend/krakend-metrics/v2"
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestNewTopHandler(t *testing.T) {
+	registry := metrics.NewRegistry()
+	rm := krakendmetrics.NewRouterMetrics(&registry)
+
+	w := httptest.NewRecorder()
+	NewTopHandler(rm.HeavyHitters()).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/__stats/top", http.NoBody))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unexpected status code: %d", w.Code)
+	}
+
+	h, _ := krakendmetrics.NewHeavyHitters(&krakendmetrics.TopConfig{K: 5})
+	rm.EnableHeavyHitters(h)
+	hf := NewHTTPHandler("/foo/{id}", http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}), rm)
+	rh := NewRouterHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		hf(w, r)
+	}), rm)
+	for _, path := range []string{"/foo/1", "/foo/1", "/foo/2", "/missing"} {
+		req := httptest.NewRequest(http.MethodGet, path, http.NoBody)
+		req.RemoteAddr = "1.2.3.4:5678"
+		rh.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	w = httptest.NewRecorder()
+	NewTopHandler(rm.HeavyHitters()).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/__stats/top", http.NoBody))
+	if w.Code != http.StatusOK {
+		t.Errorf("unexpected status code: %d", w.Code)
+	}
+	var report krakendmetrics.TopReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.IPs) != 1 || report.IPs[0] != (krakendmetrics.TopEntry{Key: "1.2.3.4", Count: 4}) {
+		t.Errorf("unexpected ips: %+v", report.IPs)
+	}
+	if len(report.Paths) != 3 || report.Paths[0] != (krakendmetrics.TopEntry{Key: "/foo/1", Count: 2}) {
+		t.Errorf("unexpected paths: %+v", report.Paths)
+	}
+}
@@ -0,0 +1,26 @@
+package mux
+
+import (
+	"embed"
+	"net/http"
+)
+
+//go:embed ui/index.html
+var uiFS embed.FS
+
+// NewUIHandler creates an http.Handler serving the stats dashboard. The dashboard is self-contained and
+// feeds from the snapshot stream, so it must be served next to it (/__stats/ui and /__stats/stream)
+func (*Metrics) NewUIHandler() http.Handler {
+	return NewUIHandler()
+}
+
+// NewUIHandler creates an http.Handler serving the stats dashboard. The dashboard is self-contained and
+// feeds from the snapshot stream, so it must be served next to it (/__stats/ui and /__stats/stream)
+func NewUIHandler() http.Handler {
+	page, _ := uiFS.ReadFile("ui/index.html")
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+		w.Write(page)
+	})
+}
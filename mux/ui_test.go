@@ -0,0 +1,29 @@
+package mux
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestNewUIHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/__stats/ui", http.NoBody)
+	NewUIHandler().ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("unexpected status code: %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("unexpected content type: %s", ct)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, `new EventSource("stream")`) {
+		t.Error("the dashboard should feed from the snapshot stream")
+	}
+	if external := regexp.MustCompile(`(src|href)="(https?:)?//`).FindString(body); external != "" {
+		t.Errorf("the dashboard should not load external resources: %s", external)
+	}
+}
@@ -0,0 +1,157 @@
+package mux
+
+import (
+	"io"
+	"net/http"
+	"syscall"
+)
+
+// instrumentedWriter is implemented by the response writers of this package. They implement every
+// optional interface, but only the ones supported by the wrapped writer should be exposed to the handlers
+type instrumentedWriter interface {
+	http.ResponseWriter
+	http.Flusher
+	http.Hijacker
+	http.Pusher
+	io.ReaderFrom
+	Unwrap() http.ResponseWriter
+}
+
+type baseWriter interface {
+	http.ResponseWriter
+	Unwrap() http.ResponseWriter
+}
+
+const (
+	supportsFlusher = 1 << iota
+	supportsHijacker
+	supportsPusher
+	supportsReaderFrom
+)
+
+// exposeWriter returns a view of the instrumented writer implementing the same optional interfaces
+// (http.Flusher, http.Hijacker, http.Pusher and io.ReaderFrom) as the wrapped one, so type assertions
+// done by the handlers behave as if there were no instrumentation at all
+func exposeWriter(w instrumentedWriter, wrapped http.ResponseWriter) http.ResponseWriter {
+	var features int
+	if _, ok := wrapped.(http.Flusher); ok {
+		features |= supportsFlusher
+	}
+	if _, ok := wrapped.(http.Hijacker); ok {
+		features |= supportsHijacker
+	}
+	if _, ok := wrapped.(http.Pusher); ok {
+		features |= supportsPusher
+	}
+	if _, ok := wrapped.(io.ReaderFrom); ok {
+		features |= supportsReaderFrom
+	}
+
+	switch features {
+	case 0:
+		return struct{ baseWriter }{w}
+	case supportsFlusher:
+		return struct {
+			baseWriter
+			http.Flusher
+		}{w, w}
+	case supportsHijacker:
+		return struct {
+			baseWriter
+			http.Hijacker
+		}{w, w}
+	case supportsFlusher | supportsHijacker:
+		return struct {
+			baseWriter
+			http.Flusher
+			http.Hijacker
+		}{w, w, w}
+	case supportsPusher:
+		return struct {
+			baseWriter
+			http.Pusher
+		}{w, w}
+	case supportsFlusher | supportsPusher:
+		return struct {
+			baseWriter
+			http.Flusher
+			http.Pusher
+		}{w, w, w}
+	case supportsHijacker | supportsPusher:
+		return struct {
+			baseWriter
+			http.Hijacker
+			http.Pusher
+		}{w, w, w}
+	case supportsFlusher | supportsHijacker | supportsPusher:
+		return struct {
+			baseWriter
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+		}{w, w, w, w}
+	case supportsReaderFrom:
+		return struct {
+			baseWriter
+			io.ReaderFrom
+		}{w, w}
+	case supportsFlusher | supportsReaderFrom:
+		return struct {
+			baseWriter
+			http.Flusher
+			io.ReaderFrom
+		}{w, w, w}
+	case supportsHijacker | supportsReaderFrom:
+		return struct {
+			baseWriter
+			http.Hijacker
+			io.ReaderFrom
+		}{w, w, w}
+	case supportsFlusher | supportsHijacker | supportsReaderFrom:
+		return struct {
+			baseWriter
+			http.Flusher
+			http.Hijacker
+			io.ReaderFrom
+		}{w, w, w, w}
+	case supportsPusher | supportsReaderFrom:
+		return struct {
+			baseWriter
+			http.Pusher
+			io.ReaderFrom
+		}{w, w, w}
+	case supportsFlusher | supportsPusher | supportsReaderFrom:
+		return struct {
+			baseWriter
+			http.Flusher
+			http.Pusher
+			io.ReaderFrom
+		}{w, w, w, w}
+	case supportsHijacker | supportsPusher | supportsReaderFrom:
+		return struct {
+			baseWriter
+			http.Hijacker
+			http.Pusher
+			io.ReaderFrom
+		}{w, w, w, w}
+	default:
+		return w
+	}
+}
+
+// writerOnly hides the io.ReaderFrom implementation of the writer, so io.Copy does not call it back
+type writerOnly struct {
+	io.Writer
+}
+
+// isZeroCopySource reports whether the writers of net/http can send the source without copying it
+// (sendfile or splice): the files and the TCP connections, optionally behind an io.LimitedReader. The
+// sources are checked by their file descriptor, since os.File.WriteTo hands a wrapper of the file to
+// the io.ReaderFrom of the writer
+func isZeroCopySource(src io.Reader) bool {
+	if lr, ok := src.(*io.LimitedReader); ok {
+		src = lr.R
+	}
+	_, ok := src.(syscall.Conn)
+	return ok
+}
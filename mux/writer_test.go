@@ -0,0 +1,292 @@
+package mux
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	krakendmetrics "github.com/krakend/krakend-metrics/v2"
+	metrics "github.com/rcrowley/go-metrics"
+)
+
+// fakeWriter implements every optional interface and records which ones have been used
+type fakeWriter struct {
+	*httptest.ResponseRecorder
+	flushed  bool
+	hijacked bool
+	pushed   bool
+	readFrom bool
+}
+
+func (f *fakeWriter) Flush() { f.flushed = true }
+
+func (f *fakeWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	f.hijacked = true
+	server, client := net.Pipe()
+	client.Close()
+	return server, bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server)), nil
+}
+
+func (f *fakeWriter) Push(_ string, _ *http.PushOptions) error {
+	f.pushed = true
+	return nil
+}
+
+func (f *fakeWriter) ReadFrom(src io.Reader) (int64, error) {
+	f.readFrom = true
+	return io.Copy(f.ResponseRecorder, src)
+}
+
+// writerMatrix returns the fake writer hidden behind a view exposing only the selected interfaces
+func writerMatrix(f *fakeWriter, features int) http.ResponseWriter {
+	type base interface{ http.ResponseWriter }
+	var w http.ResponseWriter = struct{ base }{f}
+	switch features {
+	case supportsFlusher:
+		w = struct {
+			base
+			http.Flusher
+		}{f, f}
+	case supportsHijacker:
+		w = struct {
+			base
+			http.Hijacker
+		}{f, f}
+	case supportsFlusher | supportsHijacker:
+		w = struct {
+			base
+			http.Flusher
+			http.Hijacker
+		}{f, f, f}
+	case supportsPusher:
+		w = struct {
+			base
+			http.Pusher
+		}{f, f}
+	case supportsFlusher | supportsPusher:
+		w = struct {
+			base
+			http.Flusher
+			http.Pusher
+		}{f, f, f}
+	case supportsHijacker | supportsPusher:
+		w = struct {
+			base
+			http.Hijacker
+			http.Pusher
+		}{f, f, f}
+	case supportsFlusher | supportsHijacker | supportsPusher:
+		w = struct {
+			base
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+		}{f, f, f, f}
+	case supportsReaderFrom:
+		w = struct {
+			base
+			io.ReaderFrom
+		}{f, f}
+	case supportsFlusher | supportsReaderFrom:
+		w = struct {
+			base
+			http.Flusher
+			io.ReaderFrom
+		}{f, f, f}
+	case supportsHijacker | supportsReaderFrom:
+		w = struct {
+			base
+			http.Hijacker
+			io.ReaderFrom
+		}{f, f, f}
+	case supportsFlusher | supportsHijacker | supportsReaderFrom:
+		w = struct {
+			base
+			http.Flusher
+			http.Hijacker
+			io.ReaderFrom
+		}{f, f, f, f}
+	case supportsPusher | supportsReaderFrom:
+		w = struct {
+			base
+			http.Pusher
+			io.ReaderFrom
+		}{f, f, f}
+	case supportsFlusher | supportsPusher | supportsReaderFrom:
+		w = struct {
+			base
+			http.Flusher
+			http.Pusher
+			io.ReaderFrom
+		}{f, f, f, f}
+	case supportsHijacker | supportsPusher | supportsReaderFrom:
+		w = struct {
+			base
+			http.Hijacker
+			http.Pusher
+			io.ReaderFrom
+		}{f, f, f, f}
+	case supportsFlusher | supportsHijacker | supportsPusher | supportsReaderFrom:
+		w = f
+	}
+	return w
+}
+
+func TestNewHTTPHandler_writerMatrix(t *testing.T) {
+	for features := 0; features < 16; features++ {
+		registry := metrics.NewRegistry()
+		rm := krakendmetrics.NewRouterMetrics(&registry)
+		f := &fakeWriter{ResponseRecorder: httptest.NewRecorder()}
+
+		var exposed []bool
+		h := NewHTTPHandler("test", http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			fl, isFlusher := w.(http.Flusher)
+			hj, isHijacker := w.(http.Hijacker)
+			p, isPusher := w.(http.Pusher)
+			rf, isReaderFrom := w.(io.ReaderFrom)
+			exposed = []bool{isFlusher, isHijacker, isPusher, isReaderFrom}
+
+			if u, ok := w.(interface{ Unwrap() http.ResponseWriter }); !ok || u.Unwrap() == nil {
+				t.Errorf("%d: the writer should be unwrappable", features)
+			}
+			if isPusher {
+				p.Push("/style.css", nil)
+			}
+			if isReaderFrom {
+				rf.ReadFrom(strings.NewReader("payload"))
+			} else {
+				io.Copy(w, strings.NewReader("payload"))
+			}
+			if isFlusher {
+				fl.Flush()
+			}
+			if isHijacker {
+				conn, _, err := hj.Hijack()
+				if err != nil {
+					t.Errorf("%d: unexpected error: %s", features, err)
+					return
+				}
+				conn.Close()
+			}
+		}), rm)
+
+		h(writerMatrix(f, features), httptest.NewRequest("GET", "/", http.NoBody))
+
+		for i, used := range []bool{f.flushed, f.hijacked, f.pushed, f.readFrom} {
+			want := features&(1<<i) != 0
+			if exposed[i] != want {
+				t.Errorf("%d: unexpected exposure of the interface %d: %v", features, i, exposed[i])
+			}
+			if used != want {
+				t.Errorf("%d: unexpected usage of the interface %d: %v", features, i, used)
+			}
+		}
+		if f.Body.String() != "payload" {
+			t.Errorf("%d: unexpected body: %s", features, f.Body.String())
+		}
+
+		zeroCopy, _ := registry.Get("router.response.test.zero-copy.count").(metrics.Counter)
+		switch {
+		case features&supportsHijacker != 0:
+			if zeroCopy != nil && zeroCopy.Count() != 0 {
+				t.Errorf("%d: hijacked connections should not be recorded as responses", features)
+			}
+		default:
+			// the payload is not a file, so it is copied even through the io.ReaderFrom of the writer
+			if zeroCopy != nil {
+				t.Errorf("%d: the zero-copy path should not be recorded", features)
+			}
+			if size := registry.Get("router.response.test.size").(metrics.Histogram).Max(); size != 7 {
+				t.Errorf("%d: unexpected size: %d", features, size)
+			}
+		}
+	}
+}
+
+func TestNewHTTPHandler_responseController(t *testing.T) {
+	registry := metrics.NewRegistry()
+	rm := krakendmetrics.NewRouterMetrics(&registry)
+
+	h := NewHTTPHandler("test", http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		rc := http.NewResponseController(w)
+		if err := rc.EnableFullDuplex(); err != nil {
+			t.Errorf("the response controller should reach the server writer: %s", err)
+		}
+		w.Write([]byte("ok"))
+		if err := rc.Flush(); err != nil {
+			t.Error(err)
+		}
+	}), rm)
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	resp.Body.Close()
+
+	if h := registry.Get("router.response.test.stream.flushes").(metrics.Histogram); h.Max() != 1 {
+		t.Errorf("unexpected number of flushes: %d", h.Max())
+	}
+}
+
+func TestNewHTTPHandler_zeroCopy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "payload")
+	if err := os.WriteFile(path, []byte("payload"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	registry := metrics.NewRegistry()
+	rm := krakendmetrics.NewRouterMetrics(&registry)
+	h := NewHTTPHandler("test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer f.Close()
+		if r.URL.Path == "/limited" {
+			io.Copy(w, io.LimitReader(f, 4))
+			return
+		}
+		io.Copy(w, f)
+	}), rm)
+
+	for _, path := range []string{"/", "/limited"} {
+		f := &fakeWriter{ResponseRecorder: httptest.NewRecorder()}
+		h(writerMatrix(f, supportsReaderFrom), httptest.NewRequest("GET", path, http.NoBody))
+		if !f.readFrom {
+			t.Errorf("%s: the io.ReaderFrom of the writer has not been used", path)
+		}
+	}
+	if c := registry.Get("router.response.test.zero-copy.count").(metrics.Counter).Count(); c != 2 {
+		t.Errorf("unexpected zero-copy responses: %d", c)
+	}
+	if size := registry.Get("router.response.test.size").(metrics.Histogram).Max(); size != 7 {
+		t.Errorf("unexpected size: %d", size)
+	}
+}
+
+func TestIsZeroCopySource(t *testing.T) {
+	for _, tc := range []struct {
+		src  io.Reader
+		want bool
+	}{
+		{os.Stdin, true},
+		{io.LimitReader(os.Stdin, 1), true},
+		{&net.TCPConn{}, true},
+		{strings.NewReader("payload"), false},
+		{io.LimitReader(strings.NewReader("payload"), 1), false},
+	} {
+		if have := isZeroCopySource(tc.src); have != tc.want {
+			t.Errorf("%T: unexpected result: %v", tc.src, have)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package metrics
+
+import "time"
+
+// PprofConfig enables the profiling routes on the stats server
+type PprofConfig struct {
+	// MaxDuration is the maximum duration of the CPU profiles and the execution traces
+	MaxDuration time.Duration
+}
+
+var defaultMaxProfileDuration = 30 * time.Second
+
+func parsePprofConfig(data map[string]interface{}) *PprofConfig {
+	v, ok := data["pprof"]
+	if !ok {
+		return nil
+	}
+	tmp, ok := v.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	cfg := &PprofConfig{MaxDuration: defaultMaxProfileDuration}
+	if maxDuration, ok := tmp["max_duration"].(string); ok {
+		if d, err := time.ParseDuration(maxDuration); err == nil {
+			// the profiles are at least one second long, as enforced by the handlers
+			cfg.MaxDuration = max(d, time.Second)
+		}
+	}
+	return cfg
+}
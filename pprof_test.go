@@ -0,0 +1,25 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParsePprofConfig(t *testing.T) {
+	for i, tc := range []struct {
+		cfg  map[string]interface{}
+		want *PprofConfig
+	}{
+		{cfg: map[string]interface{}{}},
+		{cfg: map[string]interface{}{"pprof": map[string]interface{}{}}, want: &PprofConfig{MaxDuration: defaultMaxProfileDuration}},
+		{cfg: map[string]interface{}{"pprof": map[string]interface{}{"max_duration": "10s"}}, want: &PprofConfig{MaxDuration: 10 * time.Second}},
+		{cfg: map[string]interface{}{"pprof": map[string]interface{}{"max_duration": "500ms"}}, want: &PprofConfig{MaxDuration: time.Second}},
+		{cfg: map[string]interface{}{"pprof": map[string]interface{}{"max_duration": "-1s"}}, want: &PprofConfig{MaxDuration: time.Second}},
+		{cfg: map[string]interface{}{"pprof": map[string]interface{}{"max_duration": "abc"}}, want: &PprofConfig{MaxDuration: defaultMaxProfileDuration}},
+	} {
+		have := parsePprofConfig(tc.cfg)
+		if (have == nil) != (tc.want == nil) || (have != nil && *have != *tc.want) {
+			t.Errorf("%d: unexpected config. have: %+v, want: %+v", i, have, tc.want)
+		}
+	}
+}
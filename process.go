@@ -0,0 +1,34 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// processStats are the OS level stats of the running process
+type processStats struct {
+	CPUUser                time.Duration
+	CPUSystem              time.Duration
+	ResidentMemory         int64
+	VirtualMemory          int64
+	OpenFDs                int64
+	MaxFDs                 int64
+	Threads                int64
+	VoluntaryCtxSwitches   int64
+	InvoluntaryCtxSwitches int64
+	StartTime              int64
+}
+
+func (s processStats) update(r metrics.Registry) {
+	metrics.GetOrRegisterGauge("process.cpu.user", r).Update(int64(s.CPUUser))
+	metrics.GetOrRegisterGauge("process.cpu.system", r).Update(int64(s.CPUSystem))
+	metrics.GetOrRegisterGauge("process.memory.resident", r).Update(s.ResidentMemory)
+	metrics.GetOrRegisterGauge("process.memory.virtual", r).Update(s.VirtualMemory)
+	metrics.GetOrRegisterGauge("process.fds.open", r).Update(s.OpenFDs)
+	metrics.GetOrRegisterGauge("process.fds.max", r).Update(s.MaxFDs)
+	metrics.GetOrRegisterGauge("process.threads", r).Update(s.Threads)
+	metrics.GetOrRegisterGauge("process.context_switches.voluntary", r).Update(s.VoluntaryCtxSwitches)
+	metrics.GetOrRegisterGauge("process.context_switches.involuntary", r).Update(s.InvoluntaryCtxSwitches)
+	metrics.GetOrRegisterGauge("process.start_time", r).Update(s.StartTime)
+}
@@ -0,0 +1,189 @@
+//go:build linux
+
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// userHZ is the number of clock ticks per second used by the kernel to report the cpu times. It is
+// 100 in every supported architecture
+const userHZ = 100
+
+// processCollector reads the stats of the process from the procfs mounted at root
+type processCollector struct {
+	registry metrics.Registry
+	root     string
+	pid      string
+	pageSize int64
+}
+
+func newProcessCollector(r metrics.Registry) *processCollector {
+	return &processCollector{registry: r, root: "/proc", pid: "self", pageSize: int64(os.Getpagesize())}
+}
+
+func (c *processCollector) collect() error {
+	s, err := c.read()
+	if err != nil {
+		return err
+	}
+	s.update(c.registry)
+	return nil
+}
+
+func (c *processCollector) read() (processStats, error) {
+	s := processStats{}
+	dir := filepath.Join(c.root, c.pid)
+
+	if err := c.readStat(dir, &s); err != nil {
+		return s, err
+	}
+	if err := readStatus(dir, &s); err != nil {
+		return s, err
+	}
+	if err := readLimits(dir, &s); err != nil {
+		return s, err
+	}
+	fds, err := os.ReadDir(filepath.Join(dir, "fd"))
+	if err != nil {
+		return s, err
+	}
+	s.OpenFDs = int64(len(fds))
+	return s, nil
+}
+
+func (c *processCollector) readStat(dir string, s *processStats) error {
+	b, err := os.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return err
+	}
+	// the command name is enclosed in parentheses and it may contain spaces and parentheses itself
+	i := bytes.LastIndexByte(b, ')')
+	if i < 0 {
+		return errors.New("malformed stat file")
+	}
+	// the fields after the command name start at the 3rd field of the file (state)
+	fields := strings.Fields(string(b[i+1:]))
+	if len(fields) < 22 {
+		return errors.New("malformed stat file")
+	}
+	values := make([]int64, 3)
+	for j, k := range []int{11, 12, 17} {
+		if values[j], err = strconv.ParseInt(fields[k], 10, 64); err != nil {
+			return fmt.Errorf("malformed stat file: %w", err)
+		}
+	}
+	s.CPUUser = time.Duration(values[0]) * time.Second / userHZ
+	s.CPUSystem = time.Duration(values[1]) * time.Second / userHZ
+	s.Threads = values[2]
+	if s.VirtualMemory, err = strconv.ParseInt(fields[20], 10, 64); err != nil {
+		return fmt.Errorf("malformed stat file: %w", err)
+	}
+	rss, err := strconv.ParseInt(fields[21], 10, 64)
+	if err != nil {
+		return fmt.Errorf("malformed stat file: %w", err)
+	}
+	s.ResidentMemory = rss * c.pageSize
+
+	bootTime, err := readBootTime(c.root)
+	if err != nil {
+		return err
+	}
+	startTicks, err := strconv.ParseInt(fields[19], 10, 64)
+	if err != nil {
+		return fmt.Errorf("malformed stat file: %w", err)
+	}
+	s.StartTime = bootTime + startTicks/userHZ
+	return nil
+}
+
+func readBootTime(root string) (int64, error) {
+	f, err := os.Open(filepath.Join(root, "stat"))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if v, ok := strings.CutPrefix(sc.Text(), "btime "); ok {
+			return strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return 0, err
+	}
+	return 0, errors.New("btime not found")
+}
+
+func readStatus(dir string, s *processStats) error {
+	f, err := os.Open(filepath.Join(dir, "status"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		k, v, ok := strings.Cut(sc.Text(), ":")
+		if !ok {
+			continue
+		}
+		var dst *int64
+		switch k {
+		case "voluntary_ctxt_switches":
+			dst = &s.VoluntaryCtxSwitches
+		case "nonvoluntary_ctxt_switches":
+			dst = &s.InvoluntaryCtxSwitches
+		default:
+			continue
+		}
+		if *dst, err = strconv.ParseInt(strings.TrimSpace(v), 10, 64); err != nil {
+			return fmt.Errorf("malformed status file: %w", err)
+		}
+	}
+	return sc.Err()
+}
+
+// readLimits reads the soft limit of open files. Unlimited is reported as -1
+func readLimits(dir string, s *processStats) error {
+	f, err := os.Open(filepath.Join(dir, "limits"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		v, ok := strings.CutPrefix(sc.Text(), "Max open files")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(v)
+		if len(fields) == 0 {
+			return errors.New("malformed limits file")
+		}
+		if fields[0] == "unlimited" {
+			s.MaxFDs = -1
+			return nil
+		}
+		if s.MaxFDs, err = strconv.ParseInt(fields[0], 10, 64); err != nil {
+			return fmt.Errorf("malformed limits file: %w", err)
+		}
+		return nil
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return errors.New("max open files not found")
+}
@@ -0,0 +1,91 @@
+//go:build linux
+
+package metrics
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestProcessCollector(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "42")
+	if err := os.MkdirAll(filepath.Join(dir, "fd"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := os.WriteFile(filepath.Join(dir, "fd", fmt.Sprint(i)), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := map[string]string{
+		filepath.Join(root, "stat"): "cpu  1 2 3 4\nbtime 1700000000\nprocesses 10\n",
+		filepath.Join(dir, "stat"): "42 (krakend (gw)) S 1 42 42 0 -1 4194560 1 0 0 0 " +
+			"250 120 0 0 20 0 7 0 1500 104857600 2560 18446744073709551615 1 1 0 0 0 0 0 0 0 0 0 0 17 3 0 0 0 0 0\n",
+		filepath.Join(dir, "status"): "Name:\tkrakend\nThreads:\t7\nvoluntary_ctxt_switches:\t150\nnonvoluntary_ctxt_switches:\t12\n",
+		filepath.Join(dir, "limits"): "Limit                     Soft Limit           Hard Limit           Units     \n" +
+			"Max cpu time              unlimited            unlimited            seconds   \n" +
+			"Max open files            1024                 524288               files     \n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := metrics.NewRegistry()
+	c := &processCollector{registry: r, root: root, pid: "42", pageSize: 4096}
+	if err := c.collect(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]int64{
+		"process.cpu.user":                     int64(2500 * time.Millisecond),
+		"process.cpu.system":                   int64(1200 * time.Millisecond),
+		"process.memory.resident":              2560 * 4096,
+		"process.memory.virtual":               104857600,
+		"process.fds.open":                     3,
+		"process.fds.max":                      1024,
+		"process.threads":                      7,
+		"process.context_switches.voluntary":   150,
+		"process.context_switches.involuntary": 12,
+		"process.start_time":                   1700000015,
+	} {
+		g, ok := r.Get(name).(metrics.Gauge)
+		if !ok {
+			t.Errorf("%s not registered", name)
+			continue
+		}
+		if v := g.Value(); v != want {
+			t.Errorf("%s: want %d, have %d", name, want, v)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "stat"), []byte("42 krakend S 1"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.collect(); err == nil {
+		t.Error("error expected")
+	}
+}
+
+func TestProcessCollector_self(t *testing.T) {
+	if _, err := os.Stat("/proc/self/stat"); err != nil {
+		t.Skip("procfs not available")
+	}
+	r := metrics.NewRegistry()
+	if err := newProcessCollector(r).collect(); err != nil {
+		t.Fatal(err)
+	}
+	if v := r.Get("process.memory.resident").(metrics.Gauge).Value(); v <= 0 {
+		t.Errorf("unexpected rss: %d", v)
+	}
+	if v := r.Get("process.start_time").(metrics.Gauge).Value(); v <= 0 || v > time.Now().Unix() {
+		t.Errorf("unexpected start time: %d", v)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package metrics
+
+import "github.com/rcrowley/go-metrics"
+
+// processCollector is a no-op outside linux
+type processCollector struct{}
+
+func newProcessCollector(_ metrics.Registry) *processCollector { return &processCollector{} }
+
+func (*processCollector) collect() error { return nil }
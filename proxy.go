@@ -49,7 +49,7 @@ func (m *Metrics) DefaultBackendFactory() proxy.BackendFactory {
 // NewProxyMetrics creates a ProxyMetrics using the injected registry
 func NewProxyMetrics(parent *metrics.Registry) *ProxyMetrics {
 	m := metrics.NewPrefixedChildRegistry(*parent, "proxy.")
-	return &ProxyMetrics{m}
+	return &ProxyMetrics{register: m, prefix: "proxy."}
 }
 
 // NewProxyMiddleware creates a proxy middleware ready to be injected in the pipe as instrumentation point
@@ -60,16 +60,33 @@ func NewProxyMiddleware(layer, name string, pm *ProxyMetrics) proxy.Middleware {
 			panic(proxy.ErrTooManyProxies)
 		}
 		return func(ctx context.Context, request *proxy.Request) (*proxy.Response, error) {
-			begin := time.Now()
+			begin := pm.Now()
 			resp, err := next[0](ctx, request)
+			end := pm.Now()
+
+			var slow SlowRequest
+			if pm.slow != nil {
+				slow = SlowRequest{Layer: layer, Name: name, Path: request.Path, Time: end}
+				if ids := request.Headers[pm.slow.RequestIDHeader()]; len(ids) > 0 {
+					slow.RequestID = ids[0]
+				}
+			}
 
 			go func(duration int64, resp *proxy.Response, err error) {
 				errored := strconv.FormatBool(err != nil)
 				complete := strconv.FormatBool(resp != nil && resp.IsComplete)
 				labels := "layer." + layer + ".name." + name + ".complete." + complete + ".error." + errored
 				pm.Counter("requests." + labels).Inc(1)
-				pm.Histogram("latency." + labels).Update(duration)
-			}(time.Since(begin).Nanoseconds(), resp, err)
+				pm.durationHistogram("latency." + labels).Update(duration)
+
+				if pm.slow != nil {
+					slow.Duration = time.Duration(duration)
+					if resp != nil {
+						slow.Status = resp.Metadata.StatusCode
+					}
+					pm.slow.Observe(pm.prefix+"latency."+labels, slow)
+				}
+			}(end.Sub(begin).Nanoseconds(), resp, err)
 
 			return resp, err
 		}
@@ -82,7 +99,7 @@ func registerProxyMiddlewareMetrics(layer, name string, pm *ProxyMetrics) {
 		for _, errored := range []string{"true", "false"} {
 			metrics.GetOrRegisterCounter("requests."+labels+".complete."+complete+".error."+errored, pm.register)
 
-			metrics.GetOrRegisterHistogram("latency."+labels+".complete."+complete+".error."+errored, pm.register, defaultSample())
+			pm.durationHistogram("latency." + labels + ".complete." + complete + ".error." + errored)
 		}
 	}
 }
@@ -90,14 +107,42 @@ func registerProxyMiddlewareMetrics(layer, name string, pm *ProxyMetrics) {
 // ProxyMetrics is the metrics collector for the proxy package
 type ProxyMetrics struct {
 	register metrics.Registry
+	prefix   string
+	slow     *SlowRequests
+	clock    func() time.Time
+}
+
+// SetClock replaces the clock measuring the durations recorded by the collector. It must be set before
+// using the collector. It is meant for the tests requiring deterministic durations
+func (rm *ProxyMetrics) SetClock(now func() time.Time) {
+	rm.clock = now
+}
+
+// Now returns the current time of the clock of the collector
+func (rm *ProxyMetrics) Now() time.Time {
+	if rm.clock == nil {
+		return time.Now()
+	}
+	return rm.clock()
 }
 
 // Histogram gets or register a histogram
 func (rm *ProxyMetrics) Histogram(labels ...string) metrics.Histogram {
-	return metrics.GetOrRegisterHistogram(strings.Join(labels, "."), rm.register, defaultSample())
+	return getOrRegisterHistogram(strings.Join(labels, "."), rm.register)
+}
+
+// durationHistogram gets or register a histogram of durations, counting the buckets of the slow
+// requests config if it is enabled
+func (rm *ProxyMetrics) durationHistogram(labels ...string) metrics.Histogram {
+	return getOrRegisterBucketedHistogram(strings.Join(labels, "."), rm.register, rm.slow.bucketBounds())
 }
 
 // Counter gets or register a counter
 func (rm *ProxyMetrics) Counter(labels ...string) metrics.Counter {
 	return metrics.GetOrRegisterCounter(strings.Join(labels, "."), rm.register)
 }
+
+// Gauge gets or register a gauge
+func (rm *ProxyMetrics) Gauge(labels ...string) metrics.Gauge {
+	return metrics.GetOrRegisterGauge(strings.Join(labels, "."), rm.register)
+}
@@ -2,6 +2,13 @@ package metrics
 
 import (
 	"crypto/tls"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
 
 	metrics "github.com/rcrowley/go-metrics"
 )
@@ -11,13 +18,13 @@ func NewRouterMetrics(parent *metrics.Registry) *RouterMetrics {
 	r := metrics.NewPrefixedChildRegistry(*parent, "router.")
 
 	return &RouterMetrics{
-		ProxyMetrics{r},
-		metrics.NewRegisteredCounter("connected", r),
-		metrics.NewRegisteredCounter("disconnected", r),
-		metrics.NewRegisteredCounter("connected-total", r),
-		metrics.NewRegisteredCounter("disconnected-total", r),
-		metrics.NewRegisteredGauge("connected-gauge", r),
-		metrics.NewRegisteredGauge("disconnected-gauge", r),
+		ProxyMetrics:      ProxyMetrics{register: r, prefix: "router."},
+		connected:         metrics.NewRegisteredCounter("connected", r),
+		disconnected:      metrics.NewRegisteredCounter("disconnected", r),
+		connectedTotal:    metrics.NewRegisteredCounter("connected-total", r),
+		disconnectedTotal: metrics.NewRegisteredCounter("disconnected-total", r),
+		connectedGauge:    metrics.NewRegisteredGauge("connected-gauge", r),
+		disconnectedGauge: metrics.NewRegisteredGauge("disconnected-gauge", r),
 	}
 }
 
@@ -30,6 +37,10 @@ type RouterMetrics struct {
 	disconnectedTotal metrics.Counter
 	connectedGauge    metrics.Gauge
 	disconnectedGauge metrics.Gauge
+	hijacked          atomic.Int64
+	consumers         *consumers
+	heavyHitters      *HeavyHitters
+	apdex             *Apdex
 }
 
 // Connection adds one to the internal connected counter
@@ -63,5 +74,88 @@ func (rm *RouterMetrics) RegisterResponseWriterMetrics(name string) {
 	rm.Counter("response", name, "status")
 
 	rm.Histogram("response", name, "size")
-	rm.Histogram("response", name, "time")
+	rm.durationHistogram("response", name, "time")
+	rm.Histogram("response", name, "ttfb")
+	rm.apdex.register(name)
+}
+
+const (
+	// NoRouteEndpoint is the synthetic endpoint name used for the requests not matching any route
+	NoRouteEndpoint = "__no_route"
+	// MethodNotAllowedEndpoint is the synthetic endpoint name used for the requests matching a route
+	// but not its method
+	MethodNotAllowedEndpoint = "__method_not_allowed"
+)
+
+// RegisterUnmatchedMetrics registers the response metrics of the synthetic endpoints
+func (rm *RouterMetrics) RegisterUnmatchedMetrics() {
+	rm.RegisterResponseWriterMetrics(NoRouteEndpoint)
+	rm.RegisterResponseWriterMetrics(MethodNotAllowedEndpoint)
+}
+
+// Unmatched records a response not produced by any instrumented endpoint under the synthetic endpoint
+// matching its status code. It returns false if the status does not correspond to an unmatched route.
+func (rm *RouterMetrics) Unmatched(status int, size int64, duration time.Duration) bool {
+	var name string
+	switch status {
+	case http.StatusNotFound:
+		name = NoRouteEndpoint
+	case http.StatusMethodNotAllowed:
+		name = MethodNotAllowedEndpoint
+	default:
+		return false
+	}
+	rm.Counter("response", name, "status", strconv.Itoa(status), "count").Inc(1)
+	rm.Histogram("response", name, "size").Update(size)
+	rm.Histogram("response", name, "time").Update(int64(duration))
+	return true
+}
+
+// Panic counts a recovered panic using the sanitized type of the recovered value as label (ex:
+// router.panic.errors_errorString.count)
+func (rm *RouterMetrics) Panic(v interface{}) {
+	rm.Counter("panic", sanitizeSegment(strings.TrimLeft(fmt.Sprintf("%T", v), "*")), "count").Inc(1)
+}
+
+// sanitizeSegment replaces every char of a user-supplied segment of a metric name not being a letter, a
+// digit, '_' or '-' with '_', so the segment does not add levels to the dotted names
+func sanitizeSegment(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r == '-' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// ResponseStreamed records the time-to-first-byte of a response and, if the handler flushed it at least
+// once, the number of flushes, the bytes streamed and the time spent streaming after the first byte.
+// A zero firstByte means nothing has been written, so the end time is used instead.
+func (rm *RouterMetrics) ResponseStreamed(name string, begin, firstByte, end time.Time, flushes int, size int64) {
+	if firstByte.IsZero() {
+		firstByte = end
+	}
+	rm.Histogram("response", name, "ttfb").Update(int64(firstByte.Sub(begin)))
+	if flushes == 0 {
+		return
+	}
+	rm.Histogram("response", name, "stream", "flushes").Update(int64(flushes))
+	rm.Histogram("response", name, "stream", "size").Update(size)
+	rm.Histogram("response", name, "stream", "time").Update(int64(end.Sub(firstByte)))
+}
+
+// SlowResponse reports the response of an instrumented endpoint to the slow requests log, if enabled
+func (rm *RouterMetrics) SlowResponse(name string, r *http.Request, status int, d time.Duration) {
+	if rm.slow == nil {
+		return
+	}
+	rm.slow.Observe(rm.prefix+"response."+name+".time", SlowRequest{
+		Layer:     RouterLayer,
+		Name:      name,
+		Path:      r.URL.Path,
+		Duration:  d,
+		Status:    status,
+		RequestID: r.Header.Get(rm.slow.RequestIDHeader()),
+		Time:      rm.Now(),
+	})
 }
@@ -60,3 +60,16 @@ func TestRouterMetrics(t *testing.T) {
 		}
 	}
 }
+
+func TestSanitizeSegment(t *testing.T) {
+	for in, want := range map[string]string{
+		"errors.errorString": "errors_errorString",
+		"v2.5.0-rc1":         "v2_5_0-rc1",
+		"user@example.com":   "user_example_com",
+		"plain_value":        "plain_value",
+	} {
+		if have := sanitizeSegment(in); have != want {
+			t.Errorf("unexpected segment for %s. have: %s, want: %s", in, have, want)
+		}
+	}
+}
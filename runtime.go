@@ -0,0 +1,146 @@
+package metrics
+
+import (
+	"math"
+	rtmetrics "runtime/metrics"
+	"strings"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// runtimeMetricSources maps the names of the collected metrics to the runtime/metrics names they can be
+// read from, in order of preference (the first one supported by the running go version is used)
+var runtimeMetricSources = []struct {
+	name    string
+	sources []string
+}{
+	{"runtime.sched.latency", []string{"/sched/latencies:seconds"}},
+	{"runtime.gc.pause", []string{"/sched/pauses/total/gc:seconds", "/gc/pauses:seconds"}},
+	{"runtime.gc.cycles", []string{"/gc/cycles/total:gc-cycles"}},
+	{"runtime.gc.heap.goal", []string{"/gc/heap/goal:bytes"}},
+	{"runtime.gc.heap.live", []string{"/gc/heap/live:bytes"}},
+	{"runtime.goroutines", []string{"/sched/goroutines:goroutines"}},
+	{"runtime.sync.mutex.wait", []string{"/sync/mutex/wait/total:seconds"}},
+	{"runtime.memory.total", []string{"/memory/classes/total:bytes"}},
+	{"runtime.memory.heap.objects", []string{"/memory/classes/heap/objects:bytes"}},
+}
+
+const cpuClassesPrefix = "/cpu/classes/"
+
+// runtimeHistogramQuantiles are the quantiles published for every runtime histogram
+var runtimeHistogramQuantiles = []struct {
+	suffix   string
+	quantile float64
+}{
+	{"p50", 0.5},
+	{"p90", 0.9},
+	{"p99", 0.99},
+}
+
+// runtimeCollector reads the runtime/metrics samples and publishes them as gauges. Durations are
+// published in nanoseconds. The histograms (scheduler latencies, GC pauses) are published as the
+// quantiles, the maximum and the count of the observations done since the previous collection
+type runtimeCollector struct {
+	registry metrics.Registry
+	samples  []rtmetrics.Sample
+	names    []string
+	previous map[string][]uint64
+}
+
+func newRuntimeCollector(r metrics.Registry) *runtimeCollector {
+	supported := map[string]bool{}
+	c := &runtimeCollector{registry: r, previous: map[string][]uint64{}}
+
+	for _, d := range rtmetrics.All() {
+		supported[d.Name] = true
+		if strings.HasPrefix(d.Name, cpuClassesPrefix) {
+			name := strings.TrimPrefix(d.Name, cpuClassesPrefix)
+			name = name[:strings.IndexByte(name, ':')]
+			c.add("runtime.cpu."+strings.ReplaceAll(name, "/", "."), d.Name)
+		}
+	}
+	for _, m := range runtimeMetricSources {
+		for _, source := range m.sources {
+			if supported[source] {
+				c.add(m.name, source)
+				break
+			}
+		}
+	}
+	return c
+}
+
+func (c *runtimeCollector) add(name, source string) {
+	c.names = append(c.names, name)
+	c.samples = append(c.samples, rtmetrics.Sample{Name: source})
+}
+
+func (c *runtimeCollector) collect() {
+	rtmetrics.Read(c.samples)
+	for i, s := range c.samples {
+		name := c.names[i]
+		seconds := strings.HasSuffix(s.Name, "seconds")
+
+		switch s.Value.Kind() {
+		case rtmetrics.KindUint64:
+			metrics.GetOrRegisterGauge(name, c.registry).Update(int64(s.Value.Uint64()))
+		case rtmetrics.KindFloat64:
+			v := s.Value.Float64()
+			if seconds {
+				v *= 1e9
+			}
+			metrics.GetOrRegisterGauge(name, c.registry).Update(int64(v))
+		case rtmetrics.KindFloat64Histogram:
+			c.collectHistogram(name, s.Value.Float64Histogram(), seconds)
+		}
+	}
+}
+
+func (c *runtimeCollector) collectHistogram(name string, h *rtmetrics.Float64Histogram, seconds bool) {
+	previous := c.previous[name]
+	counts := make([]uint64, len(h.Counts))
+	var total uint64
+	for i, v := range h.Counts {
+		if i < len(previous) && previous[i] <= v {
+			v -= previous[i]
+		}
+		counts[i] = v
+		total += v
+	}
+	c.previous[name] = append(previous[:0], h.Counts...)
+
+	scale := 1.0
+	if seconds {
+		scale = 1e9
+	}
+	for _, q := range runtimeHistogramQuantiles {
+		v := histogramQuantile(h.Buckets, counts, total, q.quantile)
+		metrics.GetOrRegisterGauge(name+"."+q.suffix, c.registry).Update(int64(v * scale))
+	}
+	metrics.GetOrRegisterGauge(name+".max", c.registry).Update(int64(histogramQuantile(h.Buckets, counts, total, 1) * scale))
+	metrics.GetOrRegisterGauge(name+".count", c.registry).Update(int64(total))
+}
+
+// histogramQuantile returns the upper bound of the bucket containing the quantile (or its lower bound,
+// if the bucket is unbounded). It returns 0 for empty histograms
+func histogramQuantile(buckets []float64, counts []uint64, total uint64, q float64) float64 {
+	if total == 0 {
+		return 0
+	}
+	target := uint64(math.Ceil(q * float64(total)))
+	if target == 0 {
+		target = 1
+	}
+	var acc uint64
+	for i, count := range counts {
+		acc += count
+		if acc < target {
+			continue
+		}
+		if upper := buckets[i+1]; !math.IsInf(upper, 1) {
+			return upper
+		}
+		return buckets[i]
+	}
+	return buckets[len(buckets)-1]
+}
@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"math"
+	"runtime"
+	"testing"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestRuntimeCollector(t *testing.T) {
+	r := metrics.NewRegistry()
+	c := newRuntimeCollector(r)
+	c.collect()
+	runtime.GC()
+	c.collect()
+
+	for _, name := range []string{
+		"runtime.goroutines",
+		"runtime.gc.heap.goal",
+		"runtime.gc.cycles",
+		"runtime.memory.total",
+		"runtime.cpu.total",
+		"runtime.sched.latency.p99",
+		"runtime.sched.latency.count",
+		"runtime.gc.pause.max",
+	} {
+		if r.Get(name) == nil {
+			t.Errorf("%s not registered", name)
+		}
+	}
+
+	if v := r.Get("runtime.goroutines").(metrics.Gauge).Value(); v < 1 {
+		t.Errorf("unexpected number of goroutines: %d", v)
+	}
+	if v := r.Get("runtime.gc.pause.count").(metrics.Gauge).Value(); v < 1 {
+		t.Errorf("unexpected number of gc pauses since the previous collection: %d", v)
+	}
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	buckets := []float64{math.Inf(-1), 1, 2, 4, math.Inf(1)}
+	counts := []uint64{0, 5, 4, 1}
+
+	for _, tc := range []struct {
+		q    float64
+		want float64
+	}{
+		{0.5, 2},
+		{0.9, 4},
+		{0.99, 4},
+		{1, 4},
+	} {
+		if v := histogramQuantile(buckets, counts, 10, tc.q); v != tc.want {
+			t.Errorf("q%v: want %v, have %v", tc.q, tc.want, v)
+		}
+	}
+	if v := histogramQuantile(buckets, []uint64{0, 0, 0, 1}, 1, 0.5); v != 4 {
+		t.Errorf("unexpected value for the unbounded bucket: %v", v)
+	}
+	if v := histogramQuantile(buckets, make([]uint64, 4), 0, 0.5); v != 0 {
+		t.Errorf("unexpected value for an empty histogram: %v", v)
+	}
+}
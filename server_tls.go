@@ -0,0 +1,154 @@
+package metrics
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TLSConfig holds the TLS settings of the stats server
+type TLSConfig struct {
+	// CertFile is the path of the PEM encoded server certificate
+	CertFile string
+	// KeyFile is the path of the PEM encoded private key of the server certificate
+	KeyFile string
+	// ClientCAFile is the path of the PEM encoded CAs used to verify the client certificates. If set,
+	// every client must present a valid certificate (mTLS)
+	ClientCAFile string
+	// MinVersion is the minimum TLS version accepted. The config parser sets it to 0 when the
+	// min_version is not a known TLS version, and NewServerTLSConfig rejects it
+	MinVersion uint16
+}
+
+func parseTLSConfig(data map[string]interface{}) *TLSConfig {
+	v, ok := data["listen_tls"]
+	if !ok {
+		return nil
+	}
+	tmp, ok := v.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	cfg := &TLSConfig{MinVersion: tls.VersionTLS12}
+	cfg.CertFile, _ = tmp["cert_file"].(string)
+	cfg.KeyFile, _ = tmp["key_file"].(string)
+	cfg.ClientCAFile, _ = tmp["client_ca_file"].(string)
+	if minVersion, ok := tmp["min_version"].(string); ok {
+		cfg.MinVersion = 0
+		for version, name := range tlsVersion {
+			if strings.EqualFold(name, minVersion) || strings.EqualFold(strings.TrimPrefix(name, "Version"), minVersion) {
+				cfg.MinVersion = version
+			}
+		}
+	}
+	return cfg
+}
+
+// certReloadInterval is the minimum time between two checks of the certificate files
+var certReloadInterval = time.Second
+
+// NewServerTLSConfig creates the *tls.Config of the stats server. The certificate, its key and the
+// client CAs are reloaded when their files change, so rotations do not require a restart
+func NewServerTLSConfig(cfg *TLSConfig) (*tls.Config, error) {
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, errors.New("the stats server TLS config requires a cert_file and a key_file")
+	}
+	if _, ok := tlsVersion[cfg.MinVersion]; !ok {
+		return nil, errors.New("unknown min_version in the stats server TLS config")
+	}
+
+	// the config returned for every client replaces the base one, so it must also offer HTTP/2
+	r := &certReloader{cfg: cfg, nextProtos: []string{"h2", "http/1.1"}}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		MinVersion:         cfg.MinVersion,
+		NextProtos:         r.nextProtos,
+		GetConfigForClient: r.configForClient,
+	}, nil
+}
+
+type certReloader struct {
+	cfg        *TLSConfig
+	nextProtos []string
+	mu         sync.Mutex
+	tlsCfg     *tls.Config
+	versions   string
+	lastCheck  time.Time
+}
+
+func (r *certReloader) configForClient(_ *tls.ClientHelloInfo) (*tls.Config, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.lastCheck) >= certReloadInterval {
+		r.lastCheck = time.Now()
+		if versions, err := r.fileVersions(); err == nil && versions != r.versions {
+			// a failed reload keeps serving the previous certificates
+			r.load()
+		}
+	}
+	return r.tlsCfg, nil
+}
+
+// load reads the certificate files. The caller must hold the lock or own the reloader
+func (r *certReloader) load() error {
+	versions, err := r.fileVersions()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+	if err != nil {
+		return err
+	}
+	tlsCfg := &tls.Config{
+		MinVersion:   r.cfg.MinVersion,
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   r.nextProtos,
+	}
+
+	if r.cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.cfg.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return errors.New("no valid certificates found in " + r.cfg.ClientCAFile)
+		}
+		tlsCfg.ClientCAs = pool
+		tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	r.tlsCfg = tlsCfg
+	r.versions = versions
+	r.lastCheck = time.Now()
+	return nil
+}
+
+// fileVersions returns a fingerprint of the modification time and size of the watched files
+func (r *certReloader) fileVersions() (string, error) {
+	var b strings.Builder
+	for _, name := range []string{r.cfg.CertFile, r.cfg.KeyFile, r.cfg.ClientCAFile} {
+		if name == "" {
+			continue
+		}
+		info, err := os.Stat(name)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(info.ModTime().String())
+		b.WriteString(strconv.FormatInt(info.Size(), 10))
+		b.WriteByte('|')
+	}
+	return b.String(), nil
+}
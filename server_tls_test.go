@@ -0,0 +1,180 @@
+package metrics
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewServerTLSConfig(t *testing.T) {
+	defer func(d time.Duration) { certReloadInterval = d }(certReloadInterval)
+	certReloadInterval = 0
+
+	dir := t.TempDir()
+	ca, caKey := newTestCert(t, "ca", nil, nil)
+	serverCert, serverKey := newTestCert(t, "server-1", ca, caKey)
+	clientCert, clientKey := newTestCert(t, "client", ca, caKey)
+	writeTestCert(t, filepath.Join(dir, "ca.pem"), ca, nil)
+	writeTestCert(t, filepath.Join(dir, "cert.pem"), serverCert, nil)
+	writeTestCert(t, filepath.Join(dir, "key.pem"), nil, serverKey)
+
+	cfg := ConfigGetter(map[string]interface{}{
+		Namespace: map[string]interface{}{
+			"listen_tls": map[string]interface{}{
+				"cert_file":      filepath.Join(dir, "cert.pem"),
+				"key_file":       filepath.Join(dir, "key.pem"),
+				"client_ca_file": filepath.Join(dir, "ca.pem"),
+				"min_version":    "TLS13",
+			},
+		},
+	}).(*Config)
+	if cfg.TLS.MinVersion != tls.VersionTLS13 {
+		t.Errorf("unexpected min version: %x", cfg.TLS.MinVersion)
+	}
+
+	tlsCfg, err := NewServerTLSConfig(cfg.TLS)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	server := &http.Server{
+		Handler:           http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { io.WriteString(w, "ok") }),
+		ReadHeaderTimeout: time.Second,
+		TLSConfig:         tlsCfg,
+	}
+	go server.ServeTLS(ln, "", "")
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	clientPair := tls.Certificate{Certificate: [][]byte{clientCert.Raw}, PrivateKey: clientKey}
+	get := func(withCert bool) (*http.Response, error) {
+		clientCfg := &tls.Config{RootCAs: roots, ServerName: "localhost", MinVersion: tls.VersionTLS12}
+		if withCert {
+			clientCfg.Certificates = []tls.Certificate{clientPair}
+		}
+		c := &http.Client{Transport: &http.Transport{TLSClientConfig: clientCfg, ForceAttemptHTTP2: true}}
+		return c.Get("https://" + ln.Addr().String())
+	}
+
+	if _, err := get(false); err == nil {
+		t.Error("clients without certificate should be rejected")
+	}
+
+	resp, err := get(true)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	resp.Body.Close()
+	if cn := resp.TLS.PeerCertificates[0].Subject.CommonName; cn != "server-1" {
+		t.Errorf("unexpected server certificate: %s", cn)
+	}
+	if resp.ProtoMajor != 2 {
+		t.Errorf("HTTP/2 should be negotiated. have: %s", resp.Proto)
+	}
+
+	rotated, rotatedKey := newTestCert(t, "server-2", ca, caKey)
+	writeTestCert(t, filepath.Join(dir, "cert.pem"), rotated, nil)
+	writeTestCert(t, filepath.Join(dir, "key.pem"), nil, rotatedKey)
+
+	resp, err = get(true)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	resp.Body.Close()
+	if cn := resp.TLS.PeerCertificates[0].Subject.CommonName; cn != "server-2" {
+		t.Errorf("the rotated certificate should be served. have: %s", cn)
+	}
+}
+
+func TestNewServerTLSConfig_ko(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "garbage.pem"), []byte("garbage"), 0600)
+	for i, cfg := range []*TLSConfig{
+		{},
+		{CertFile: filepath.Join(dir, "unknown.pem"), KeyFile: filepath.Join(dir, "unknown.pem")},
+		{CertFile: filepath.Join(dir, "garbage.pem"), KeyFile: filepath.Join(dir, "garbage.pem")},
+	} {
+		if _, err := NewServerTLSConfig(cfg); err == nil {
+			t.Errorf("%d: error expected", i)
+		}
+	}
+
+	cfg := parseTLSConfig(map[string]interface{}{"listen_tls": map[string]interface{}{
+		"cert_file":   filepath.Join(dir, "garbage.pem"),
+		"key_file":    filepath.Join(dir, "garbage.pem"),
+		"min_version": "TLS14",
+	}})
+	if _, err := NewServerTLSConfig(cfg); err == nil || !strings.Contains(err.Error(), "min_version") {
+		t.Errorf("the unknown min_version should be rejected. have: %v", err)
+	}
+}
+
+func newTestCert(t *testing.T, cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func writeTestCert(t *testing.T, name string, cert *x509.Certificate, key *ecdsa.PrivateKey) {
+	var block *pem.Block
+	if cert != nil {
+		block = &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}
+	} else {
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block = &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+	}
+	if err := os.WriteFile(name, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"time"
+
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/logging"
+	"github.com/rcrowley/go-metrics"
+)
+
+// modulePath is the path of this module, used to report its version in the build_info metric
+const modulePath = "github.com/krakend/krakend-metrics/v2"
+
+// processStart is the reference used to calculate the uptime of the gateway
+var processStart = time.Now()
+
+// NewFromServiceConfig creates a new metrics producer with the extra config of the service and
+// registers the gauges describing the loaded configuration: its hash, the number of endpoints and
+// backends and the build info
+func NewFromServiceConfig(ctx context.Context, cfg config.ServiceConfig, l logging.Logger) *Metrics {
+	m := New(ctx, cfg.ExtraConfig, l)
+	if m.Config == nil {
+		return m
+	}
+	if err := m.RegisterServiceConfig(cfg); err != nil {
+		l.Warning("[SERVICE: Stats] Unable to hash the service config:", err.Error())
+	}
+	return m
+}
+
+// RegisterServiceConfig registers the gauges describing the service config and the build info
+func (m *Metrics) RegisterServiceConfig(cfg config.ServiceConfig) error {
+	r := metrics.NewPrefixedChildRegistry(*(m.Registry), "service.")
+
+	backends := 0
+	for _, e := range cfg.Endpoints {
+		backends += len(e.Backend)
+	}
+	metrics.GetOrRegisterGauge("config.endpoints", r).Update(int64(len(cfg.Endpoints)))
+	metrics.GetOrRegisterGauge("config.backends", r).Update(int64(backends))
+
+	info := ReadBuildInfo()
+	metrics.GetOrRegisterGauge(
+		"build_info.go_version."+sanitizeSegment(info.GoVersion)+".version."+sanitizeSegment(moduleVersion(info)), r,
+	).Update(1)
+
+	hash, err := ConfigHash(cfg)
+	if err != nil {
+		return err
+	}
+	metrics.GetOrRegisterGauge("config.hash", r).Update(hash)
+	return nil
+}
+
+// ConfigHash returns the first 8 bytes of the sha256 hash of the service config (as calculated by
+// config.ServiceConfig.Hash) as an int64, so it can be published as a gauge
+func ConfigHash(cfg config.ServiceConfig) (int64, error) {
+	h, err := cfg.Hash()
+	if err != nil {
+		return 0, err
+	}
+	b, err := base64.StdEncoding.DecodeString(h)
+	if err != nil {
+		return 0, err
+	}
+	return int64(binary.BigEndian.Uint64(b)), nil
+}
+
+func moduleVersion(info BuildInfo) string {
+	if info.Main.Path == modulePath {
+		return info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == modulePath {
+			return dep.Version
+		}
+	}
+	return "unknown"
+}
@@ -0,0 +1,95 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/logging"
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestNewFromServiceConfig(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l, _ := logging.NewLogger("DEBUG", new(bytes.Buffer), "")
+
+	cfg := config.ServiceConfig{
+		Name: "gateway",
+		Endpoints: []*config.EndpointConfig{
+			{Endpoint: "/a", Backend: []*config.Backend{{URLPattern: "/a"}}},
+			{Endpoint: "/b", Backend: []*config.Backend{{URLPattern: "/b1"}, {URLPattern: "/b2"}}},
+		},
+		ExtraConfig: config.ExtraConfig{Namespace: map[string]interface{}{"endpoint_disabled": true}},
+	}
+	m := NewFromServiceConfig(ctx, cfg, l)
+	snapshot := m.TakeSnapshot()
+
+	if v := snapshot.Gauges["krakend.service.config.endpoints"]; v != 2 {
+		t.Errorf("unexpected number of endpoints: %d", v)
+	}
+	if v := snapshot.Gauges["krakend.service.config.backends"]; v != 3 {
+		t.Errorf("unexpected number of backends: %d", v)
+	}
+	hash, err := ConfigHash(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash == 0 || snapshot.Gauges["krakend.service.config.hash"] != hash {
+		t.Errorf("unexpected hash: %d", snapshot.Gauges["krakend.service.config.hash"])
+	}
+
+	found := false
+	for k, v := range snapshot.Gauges {
+		if strings.HasPrefix(k, "krakend.service.build_info.go_version."+sanitizeSegment(runtime.Version())+".version.") && v == 1 {
+			found = true
+			if n := len(strings.Split(strings.TrimPrefix(k, "krakend.service."), ".")); n != 5 {
+				t.Errorf("the versions should not add segments to the name: %s", k)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("build info not found: %v", snapshot.Gauges)
+	}
+}
+
+func TestNewFromServiceConfig_disabled(t *testing.T) {
+	l, _ := logging.NewLogger("DEBUG", new(bytes.Buffer), "")
+	m := NewFromServiceConfig(context.Background(), config.ServiceConfig{}, l)
+	if _, ok := (*m.Registry).(DummyRegistry); !ok {
+		t.Errorf("unexpected registry: %T", *m.Registry)
+	}
+}
+
+func TestConfigHash(t *testing.T) {
+	cfg := config.ServiceConfig{Name: "a", Endpoints: []*config.EndpointConfig{{Endpoint: "/a"}}}
+	h1, err := ConfigHash(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Name = "b"
+	if h2, _ := ConfigHash(cfg); h1 != h2 {
+		t.Error("the name of the service should be ignored")
+	}
+	cfg.Endpoints[0].Endpoint = "/b"
+	if h3, _ := ConfigHash(cfg); h1 == h3 {
+		t.Error("the hash should change with the config")
+	}
+}
+
+func TestMetrics_uptime(t *testing.T) {
+	p := metrics.NewRegistry()
+	m := Metrics{Registry: &p, Router: NewRouterMetrics(&p), latestSnapshot: NewStats()}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	called := false
+	m.processMetrics(ctx, time.Millisecond, customLogger{&called})
+	time.Sleep(20 * time.Millisecond)
+	if v := p.Get("service.uptime").(metrics.Gauge).Value(); v <= 0 {
+		t.Errorf("unexpected uptime: %d", v)
+	}
+}
@@ -0,0 +1,194 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/luraproject/lura/v2/logging"
+	"github.com/rcrowley/go-metrics"
+)
+
+// defaultSinkTimeout is the max time a sink can spend exporting a snapshot when the sink config does
+// not define its own timeout
+const defaultSinkTimeout = 5 * time.Second
+
+// Sink is a destination for the collected snapshots. The collector calls Start once, before the first
+// export, Export after every collection tick and Flush and Close when its context is done. The calls are
+// never concurrent. The exported snapshot is shared with the other sinks, so it must not be modified
+type Sink interface {
+	Start(ctx context.Context) error
+	Export(ctx context.Context, s Stats) error
+	Flush(ctx context.Context) error
+	Close() error
+}
+
+// SinkFactory creates a sink from its own config object
+type SinkFactory func(cfg map[string]interface{}, l logging.Logger) (Sink, error)
+
+var sinkFactories = struct {
+	mu sync.RWMutex
+	m  map[string]SinkFactory
+}{m: map[string]SinkFactory{}}
+
+// RegisterSink registers a sink factory with the given name, so it is used for the entry of the
+// "sinks" object of the config with the same name. Registering the same name twice replaces the
+// previous factory
+func RegisterSink(name string, f SinkFactory) {
+	sinkFactories.mu.Lock()
+	sinkFactories.m[name] = f
+	sinkFactories.mu.Unlock()
+}
+
+func getSinkFactory(name string) (SinkFactory, bool) {
+	sinkFactories.mu.RLock()
+	defer sinkFactories.mu.RUnlock()
+	f, ok := sinkFactories.m[name]
+	return f, ok
+}
+
+// SinkConfig is the config of a sink, as declared in the "sinks" object of the config
+type SinkConfig struct {
+	Name    string
+	Timeout time.Duration
+	Config  map[string]interface{}
+}
+
+func parseSinksConfig(data map[string]interface{}) []SinkConfig {
+	tmp, ok := data["sinks"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	res := make([]SinkConfig, 0, len(tmp))
+	for name, v := range tmp {
+		cfg, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		sc := SinkConfig{Name: name, Timeout: defaultSinkTimeout, Config: cfg}
+		if timeout, ok := cfg["timeout"].(string); ok {
+			if d, err := time.ParseDuration(timeout); err == nil && d > 0 {
+				sc.Timeout = d
+			}
+		}
+		res = append(res, sc)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+// sinkWorker exports the snapshots to a single sink from its own goroutine, so a slow sink does not
+// delay the collection or the other sinks. A snapshot arriving while the previous one is still being
+// exported is dropped
+type sinkWorker struct {
+	name     string
+	sink     Sink
+	timeout  time.Duration
+	queue    chan Stats
+	done     chan struct{}
+	exports  metrics.Counter
+	errors   metrics.Counter
+	timeouts metrics.Counter
+	dropped  metrics.Counter
+	logger   logging.Logger
+}
+
+type sinks []*sinkWorker
+
+// newSinks creates and starts the configured sinks. The sinks with unknown names or failing to start
+// are logged and ignored
+func newSinks(ctx context.Context, cfgs []SinkConfig, r metrics.Registry, l logging.Logger) sinks {
+	res := sinks{}
+	for _, cfg := range cfgs {
+		f, ok := getSinkFactory(cfg.Name)
+		if !ok {
+			l.Error(fmt.Sprintf("[SERVICE: Stats] Unknown sink %q", cfg.Name))
+			continue
+		}
+		s, err := f(cfg.Config, l)
+		if err != nil {
+			l.Error(fmt.Sprintf("[SERVICE: Stats] Unable to create the sink %q: %s", cfg.Name, err))
+			continue
+		}
+		startCtx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+		err = s.Start(startCtx)
+		cancel()
+		if err != nil {
+			l.Error(fmt.Sprintf("[SERVICE: Stats] Unable to start the sink %q: %s", cfg.Name, err))
+			continue
+		}
+		res = append(res, newSinkWorker(cfg, s, r, l))
+	}
+	return res
+}
+
+func newSinkWorker(cfg SinkConfig, s Sink, r metrics.Registry, l logging.Logger) *sinkWorker {
+	prefix := "sinks." + cfg.Name + "."
+	w := &sinkWorker{
+		name:     cfg.Name,
+		sink:     s,
+		timeout:  cfg.Timeout,
+		queue:    make(chan Stats, 1),
+		done:     make(chan struct{}),
+		exports:  metrics.GetOrRegisterCounter(prefix+"exports", r),
+		errors:   metrics.GetOrRegisterCounter(prefix+"errors", r),
+		timeouts: metrics.GetOrRegisterCounter(prefix+"timeouts", r),
+		dropped:  metrics.GetOrRegisterCounter(prefix+"dropped", r),
+		logger:   l,
+	}
+	go w.run()
+	return w
+}
+
+func (w *sinkWorker) run() {
+	defer close(w.done)
+	for s := range w.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), w.timeout)
+		w.record("export", w.sink.Export(ctx, s))
+		cancel()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), w.timeout)
+	w.record("flush", w.sink.Flush(ctx))
+	cancel()
+	w.record("close", w.sink.Close())
+}
+
+func (w *sinkWorker) record(op string, err error) {
+	if err == nil {
+		if op == "export" {
+			w.exports.Inc(1)
+		}
+		return
+	}
+	w.errors.Inc(1)
+	if errors.Is(err, context.DeadlineExceeded) {
+		w.timeouts.Inc(1)
+	}
+	w.logger.Debug(fmt.Sprintf("[SERVICE: Stats] Sink %q: unable to %s: %s", w.name, op, err))
+}
+
+// export sends the snapshot to every sink without waiting for them
+func (ss sinks) export(s Stats) {
+	for _, w := range ss {
+		select {
+		case w.queue <- s:
+		default:
+			w.dropped.Inc(1)
+		}
+	}
+}
+
+// close flushes and closes every sink after exporting their pending snapshots. It blocks until all the
+// sinks are closed
+func (ss sinks) close() {
+	for _, w := range ss {
+		close(w.queue)
+	}
+	for _, w := range ss {
+		<-w.done
+	}
+}
@@ -0,0 +1,148 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/logging"
+	"github.com/rcrowley/go-metrics"
+)
+
+type recordingSink struct {
+	mu       sync.Mutex
+	calls    []string
+	exported []Stats
+	delay    time.Duration
+	err      error
+	closed   chan struct{}
+}
+
+func (s *recordingSink) record(call string) {
+	s.mu.Lock()
+	s.calls = append(s.calls, call)
+	s.mu.Unlock()
+}
+
+func (s *recordingSink) Start(_ context.Context) error {
+	s.record("start")
+	return nil
+}
+
+func (s *recordingSink) Export(ctx context.Context, stats Stats) error {
+	s.record("export")
+	select {
+	case <-time.After(s.delay):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	s.mu.Lock()
+	s.exported = append(s.exported, stats)
+	s.mu.Unlock()
+	return s.err
+}
+
+func (s *recordingSink) Flush(_ context.Context) error {
+	s.record("flush")
+	return nil
+}
+
+func (s *recordingSink) Close() error {
+	s.record("close")
+	close(s.closed)
+	return nil
+}
+
+func (s *recordingSink) Calls() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.calls...)
+}
+
+func TestNew_sinks(t *testing.T) {
+	sink := &recordingSink{closed: make(chan struct{})}
+	var sinkCfg map[string]interface{}
+	RegisterSink("test-recorder", func(cfg map[string]interface{}, _ logging.Logger) (Sink, error) {
+		sinkCfg = cfg
+		return sink, nil
+	})
+	RegisterSink("test-broken", func(_ map[string]interface{}, _ logging.Logger) (Sink, error) {
+		return nil, errors.New("broken")
+	})
+
+	buf := new(bytes.Buffer)
+	l, _ := logging.NewLogger("DEBUG", buf, "")
+	ctx, cancel := context.WithCancel(context.Background())
+	m := New(ctx, config.ExtraConfig{Namespace: map[string]interface{}{
+		"collection_time": "10ms",
+		"sinks": map[string]interface{}{
+			"test-recorder": map[string]interface{}{"path": "/tmp/foo", "timeout": "1s"},
+			"test-broken":   map[string]interface{}{},
+			"test-unknown":  map[string]interface{}{},
+		},
+	}}, l)
+
+	if len(m.Config.Sinks) != 3 || m.Config.Sinks[2].Name != "test-unknown" || m.Config.Sinks[1].Timeout != time.Second {
+		t.Errorf("unexpected sinks config: %+v", m.Config.Sinks)
+	}
+	if sinkCfg["path"] != "/tmp/foo" {
+		t.Errorf("unexpected config: %v", sinkCfg)
+	}
+	if len(m.sinks) != 1 {
+		t.Errorf("unexpected number of sinks: %d", len(m.sinks))
+	}
+	for _, msg := range []string{`Unable to create the sink "test-broken": broken`, `Unknown sink "test-unknown"`} {
+		if !strings.Contains(buf.String(), msg) {
+			t.Errorf("message %q not logged: %s", msg, buf.String())
+		}
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case <-sink.closed:
+	case <-time.After(time.Second):
+		t.Fatal("the sink was not closed")
+	}
+
+	calls := sink.Calls()
+	if len(calls) < 4 || calls[0] != "start" || calls[1] != "export" ||
+		calls[len(calls)-2] != "flush" || calls[len(calls)-1] != "close" {
+		t.Errorf("unexpected calls: %v", calls)
+	}
+	exports := (*m.Registry).Get("service.sinks.test-recorder.exports").(metrics.Counter).Count()
+	if exports == 0 || exports != int64(len(sink.exported)) {
+		t.Errorf("unexpected number of exports: %d", exports)
+	}
+}
+
+func TestSinkWorker(t *testing.T) {
+	l, _ := logging.NewLogger("DEBUG", new(bytes.Buffer), "")
+	r := metrics.NewRegistry()
+	sink := &recordingSink{closed: make(chan struct{}), delay: time.Second}
+	ss := sinks{newSinkWorker(SinkConfig{Name: "slow", Timeout: 10 * time.Millisecond}, sink, r, l)}
+
+	ss.export(NewStats())
+	for len(sink.Calls()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	ss.export(NewStats())
+	ss.export(NewStats())
+	ss.close()
+
+	for name, want := range map[string]int64{
+		"sinks.slow.exports":  0,
+		"sinks.slow.errors":   2,
+		"sinks.slow.timeouts": 2,
+		"sinks.slow.dropped":  1,
+	} {
+		if v := r.Get(name).(metrics.Counter).Count(); v != want {
+			t.Errorf("%s: want %d, have %d", name, want, v)
+		}
+	}
+}
@@ -0,0 +1,323 @@
+package metrics
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+const (
+	// defaultSLOPeriod is the period of the error budget when the config does not define it
+	defaultSLOPeriod = 30 * 24 * time.Hour
+	// sloMinErrorStatus is the lowest status code of the responses consuming the error budget of the
+	// router availability objectives
+	sloMinErrorStatus = 500
+)
+
+// sloWindows are the windows of the burn rates, as recommended by the Google SRE workbook for the
+// multiwindow, multi-burn-rate alerts
+var sloWindows = []struct {
+	name string
+	d    time.Duration
+}{
+	{"5m", 5 * time.Minute},
+	{"1h", time.Hour},
+	{"6h", 6 * time.Hour},
+	{"3d", 3 * 24 * time.Hour},
+}
+
+// SLOConfig defines a service level objective of an endpoint or a backend
+type SLOConfig struct {
+	Name string
+	// Layer is the layer of the target: RouterLayer or the one of the proxy middlewares (pipe,
+	// backend...)
+	Layer string
+	// Target is the endpoint for the router layer or the name of the proxy middleware
+	Target string
+	// Objective is the ratio of good requests expected (ex: 0.999)
+	Objective float64
+	// Latency makes it a latency objective: the good requests are the ones taking up to Latency. If it
+	// is zero, the good requests are the ones without errors (availability objective)
+	Latency time.Duration
+	// Period is the time covered by the error budget
+	Period time.Duration
+}
+
+func parseSLOsConfig(data map[string]interface{}) []SLOConfig {
+	tmp, ok := data["slos"].([]interface{})
+	if !ok {
+		return nil
+	}
+	res := []SLOConfig{}
+	for _, v := range tmp {
+		s, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		cfg := SLOConfig{Layer: RouterLayer, Period: defaultSLOPeriod}
+		cfg.Name, _ = s["name"].(string)
+		cfg.Target, _ = s["target"].(string)
+		cfg.Objective, _ = s["objective"].(float64)
+		if cfg.Name == "" || cfg.Target == "" || cfg.Objective <= 0 || cfg.Objective >= 1 {
+			continue
+		}
+		if layer, ok := s["layer"].(string); ok && layer != "" {
+			cfg.Layer = layer
+		}
+		if latency, ok := s["latency"].(string); ok {
+			d, err := time.ParseDuration(latency)
+			if err != nil || d <= 0 {
+				continue
+			}
+			cfg.Latency = d
+		}
+		if period, ok := s["period"].(string); ok {
+			if d, err := time.ParseDuration(period); err == nil && d > 0 {
+				cfg.Period = d
+			}
+		}
+		res = append(res, cfg)
+	}
+	return res
+}
+
+// sloBucketsPerWindow is the number of time buckets of every window and of the period, so the memory
+// of an objective does not depend on its period nor on the collection interval
+const sloBucketsPerWindow = 60
+
+// sloBucket accumulates the good and total requests of a time slot
+type sloBucket struct {
+	slot  int64
+	good  int64
+	total int64
+}
+
+// sloWindow is a ring of time buckets covering a window. The oldest bucket is reused when a new slot
+// starts, so the covered time moves in steps of a bucket width
+type sloWindow struct {
+	width   time.Duration
+	buckets []sloBucket
+}
+
+func newSLOWindow(d time.Duration) *sloWindow {
+	return &sloWindow{
+		width:   max(d/sloBucketsPerWindow, time.Nanosecond),
+		buckets: make([]sloBucket, sloBucketsPerWindow),
+	}
+}
+
+// add accumulates the requests in the bucket of the time slot, resetting it if it belongs to an
+// expired slot
+func (w *sloWindow) add(now time.Time, good, total int64) {
+	slot := now.UnixNano() / int64(w.width)
+	b := &w.buckets[slot%int64(len(w.buckets))]
+	if b.slot != slot {
+		*b = sloBucket{slot: slot}
+	}
+	b.good += good
+	b.total += total
+}
+
+// sum returns the requests of the buckets in the window ending at now
+func (w *sloWindow) sum(now time.Time) (good, total int64) {
+	slot := now.UnixNano() / int64(w.width)
+	for _, b := range w.buckets {
+		if b.slot > slot-int64(len(w.buckets)) && b.slot <= slot {
+			good += b.good
+			total += b.total
+		}
+	}
+	return
+}
+
+// sloSource returns the cumulative count of good and total requests of an objective
+type sloSource func() (good, total int64)
+
+type slo struct {
+	cfg    SLOConfig
+	source sloSource
+	// good and total are the counts of the previous collection
+	good  int64
+	total int64
+	// period and windows accumulate the increments of the counts since the previous collection
+	period  *sloWindow
+	windows []*sloWindow
+
+	budget    metrics.GaugeFloat64
+	burnRates []metrics.GaugeFloat64
+}
+
+// SLOs tracks the error budgets and the burn rates of the service level objectives, from the increments
+// of the counts between collections. It is safe for concurrent use
+type SLOs struct {
+	mu   sync.Mutex
+	slos []*slo
+}
+
+// newSLOs creates the trackers of the objectives, taking the current counts as the baseline. The gauges
+// are registered in the registry as slo.<name>.error_budget.remaining and slo.<name>.burn_rate.<window>
+func newSLOs(cfgs []SLOConfig, router *RouterMetrics, proxy *ProxyMetrics, registry metrics.Registry, now time.Time) *SLOs {
+	if len(cfgs) == 0 {
+		return nil
+	}
+	s := &SLOs{}
+	for _, cfg := range cfgs {
+		var source sloSource
+		switch {
+		case cfg.Layer == RouterLayer && cfg.Latency > 0:
+			source = latencySLOSource(router.register, cfg.Latency, "response."+cfg.Target+".time")
+		case cfg.Layer == RouterLayer:
+			source = routerAvailabilitySLOSource(router, cfg.Target)
+		case cfg.Latency > 0:
+			labels := "latency.layer." + cfg.Layer + ".name." + cfg.Target
+			names := []string{}
+			for _, complete := range []string{"true", "false"} {
+				for _, errored := range []string{"true", "false"} {
+					names = append(names, labels+".complete."+complete+".error."+errored)
+				}
+			}
+			source = latencySLOSource(proxy.register, cfg.Latency, names...)
+		default:
+			source = proxyAvailabilitySLOSource(proxy.register, cfg.Layer, cfg.Target)
+		}
+
+		o := &slo{
+			cfg:    cfg,
+			source: source,
+			period: newSLOWindow(cfg.Period),
+			budget: metrics.GetOrRegisterGaugeFloat64("slo."+cfg.Name+".error_budget.remaining", registry),
+		}
+		for _, w := range sloWindows {
+			o.windows = append(o.windows, newSLOWindow(w.d))
+			o.burnRates = append(o.burnRates, metrics.GetOrRegisterGaugeFloat64("slo."+cfg.Name+".burn_rate."+w.name, registry))
+		}
+		o.good, o.total = source()
+		o.budget.Update(1)
+		s.slos = append(s.slos, o)
+	}
+	return s
+}
+
+// update adds the increments of the counts to the windows of every objective and updates its gauges.
+// It must be called once per collection, before the histograms are cleared. It does nothing on a nil
+// tracker
+func (s *SLOs) update(now time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, o := range s.slos {
+		o.update(now)
+	}
+}
+
+func (o *slo) update(now time.Time) {
+	good, total := o.source()
+	o.period.add(now, good-o.good, total-o.total)
+	for _, w := range o.windows {
+		w.add(now, good-o.good, total-o.total)
+	}
+	o.good, o.total = good, total
+
+	o.budget.Update(1 - o.burnRate(o.period, now))
+	for i, w := range o.windows {
+		o.burnRates[i].Update(o.burnRate(w, now))
+	}
+}
+
+// burnRate returns the ratio of bad requests during the window divided by the ratio allowed by the
+// objective. A burn rate of 1 consumes the whole error budget in the period. If the tracker is younger
+// than the window, the available part is used
+func (o *slo) burnRate(w *sloWindow, now time.Time) float64 {
+	good, total := w.sum(now)
+	if total <= 0 {
+		return 0
+	}
+	return float64(total-good) / float64(total) / (1 - o.cfg.Objective)
+}
+
+// routerAvailabilitySLOSource counts the responses of the endpoint by status code. The ones with a
+// status code lower than 500 are good
+func routerAvailabilitySLOSource(rm *RouterMetrics, endpoint string) sloSource {
+	// the registry iterates over the metrics with the names of its root registry
+	infix := rm.prefix + "response." + endpoint + ".status."
+	return func() (good, total int64) {
+		rm.register.Each(func(name string, v interface{}) {
+			c, ok := v.(metrics.Counter)
+			if !ok {
+				return
+			}
+			_, code, ok := strings.Cut(name, infix)
+			if !ok || !strings.HasSuffix(code, ".count") {
+				return
+			}
+			status, err := strconv.Atoi(strings.TrimSuffix(code, ".count"))
+			if err != nil {
+				return
+			}
+			n := c.Count()
+			total += n
+			if status < sloMinErrorStatus {
+				good += n
+			}
+		})
+		return
+	}
+}
+
+// proxyAvailabilitySLOSource counts the requests of the proxy middleware. The ones without errors
+// are good
+func proxyAvailabilitySLOSource(r metrics.Registry, layer, name string) sloSource {
+	labels := "requests.layer." + layer + ".name." + name
+	return func() (good, total int64) {
+		for _, complete := range []string{"true", "false"} {
+			for _, errored := range []string{"true", "false"} {
+				c, ok := r.Get(labels + ".complete." + complete + ".error." + errored).(metrics.Counter)
+				if !ok {
+					continue
+				}
+				n := c.Count()
+				total += n
+				if errored == "false" {
+					good += n
+				}
+			}
+		}
+		return
+	}
+}
+
+// latencySLOSource accumulates the observations of the histograms and the share of them taking up to
+// the threshold. The histograms are cleared on every collection, so it must be called once per
+// collection
+func latencySLOSource(r metrics.Registry, threshold time.Duration, histograms ...string) sloSource {
+	var good, total int64
+	return func() (int64, int64) {
+		for _, name := range histograms {
+			h, ok := r.Get(name).(metrics.Histogram)
+			if !ok {
+				continue
+			}
+			count := h.Count()
+			values := h.Sample().Values()
+			if count == 0 || len(values) == 0 {
+				continue
+			}
+			fast := 0
+			for _, v := range values {
+				if v <= int64(threshold) {
+					fast++
+				}
+			}
+			// the sample may keep a subset of the observations, so the share is applied to the count
+			good += int64(math.Round(float64(fast) / float64(len(values)) * float64(count)))
+			total += count
+		}
+		return good, total
+	}
+}
@@ -0,0 +1,166 @@
+package metrics
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestParseSLOsConfig(t *testing.T) {
+	cfgs := parseSLOsConfig(map[string]interface{}{
+		"slos": []interface{}{
+			map[string]interface{}{"name": "foo-availability", "target": "/foo", "objective": 0.999},
+			map[string]interface{}{"name": "foo-latency", "layer": "backend", "target": "/__debug/", "objective": 0.99, "latency": "200ms", "period": "168h"},
+			map[string]interface{}{"name": "no-target", "objective": 0.99},
+			map[string]interface{}{"name": "bad-objective", "target": "/foo", "objective": 1.0},
+			map[string]interface{}{"name": "bad-latency", "target": "/foo", "objective": 0.99, "latency": "fast"},
+		},
+	})
+	want := []SLOConfig{
+		{Name: "foo-availability", Layer: RouterLayer, Target: "/foo", Objective: 0.999, Period: defaultSLOPeriod},
+		{Name: "foo-latency", Layer: "backend", Target: "/__debug/", Objective: 0.99, Latency: 200 * time.Millisecond, Period: 168 * time.Hour},
+	}
+	if len(cfgs) != len(want) {
+		t.Fatalf("unexpected configs: %+v", cfgs)
+	}
+	for i := range want {
+		if cfgs[i] != want[i] {
+			t.Errorf("unexpected config #%d: %+v", i, cfgs[i])
+		}
+	}
+}
+
+func TestSLOs_routerAvailability(t *testing.T) {
+	registry := metrics.NewPrefixedRegistry("krakend.")
+	rm := NewRouterMetrics(&registry)
+	pm := NewProxyMetrics(&registry)
+	service := metrics.NewPrefixedChildRegistry(registry, "service.")
+	// responses before the tracker is created are not part of the history
+	rm.Counter("response", "/foo", "status", "500", "count").Inc(100)
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := newSLOs([]SLOConfig{{Name: "foo", Layer: RouterLayer, Target: "/foo", Objective: 0.99, Period: 24 * time.Hour}}, rm, pm, service, now)
+
+	rm.Counter("response", "/foo", "status", "200", "count").Inc(980)
+	rm.Counter("response", "/foo", "status", "404", "count").Inc(10)
+	rm.Counter("response", "/foo", "status", "500", "count").Inc(10)
+	rm.Counter("response", "/bar", "status", "500", "count").Inc(10)
+	s.update(now.Add(time.Minute))
+	assertSLOGauges(t, service, "foo", 0, map[string]float64{"5m": 1, "1h": 1, "6h": 1, "3d": 1})
+
+	rm.Counter("response", "/foo", "status", "200", "count").Inc(1000)
+	s.update(now.Add(10 * time.Minute))
+	assertSLOGauges(t, service, "foo", 0.5, map[string]float64{"5m": 0, "1h": 0.5, "6h": 0.5, "3d": 0.5})
+
+	// the first minute leaves the 1h window
+	s.update(now.Add(time.Hour + 2*time.Minute))
+	assertSLOGauges(t, service, "foo", 0.5, map[string]float64{"5m": 0, "1h": 0, "6h": 0.5, "3d": 0.5})
+}
+
+func TestSLOs_latency(t *testing.T) {
+	registry := metrics.NewPrefixedRegistry("krakend.")
+	rm := NewRouterMetrics(&registry)
+	pm := NewProxyMetrics(&registry)
+	NewProxyMiddleware("backend", "/foo", pm)
+	service := metrics.NewPrefixedChildRegistry(registry, "service.")
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := newSLOs([]SLOConfig{
+		{Name: "router", Layer: RouterLayer, Target: "/foo", Objective: 0.9, Latency: time.Millisecond, Period: time.Hour},
+		{Name: "backend", Layer: "backend", Target: "/foo", Objective: 0.9, Latency: time.Millisecond, Period: time.Hour},
+	}, rm, pm, service, now)
+
+	for _, d := range []time.Duration{time.Microsecond, time.Millisecond, 2 * time.Millisecond, time.Second} {
+		rm.Histogram("response", "/foo", "time").Update(int64(d))
+		pm.Histogram("latency.layer.backend.name./foo.complete.true.error.false").Update(int64(d))
+		pm.Histogram("latency.layer.backend.name./foo.complete.false.error.true").Update(int64(d))
+	}
+	s.update(now.Add(time.Minute))
+	assertSLOGauges(t, service, "router", -4, map[string]float64{"5m": 5})
+	assertSLOGauges(t, service, "backend", -4, map[string]float64{"5m": 5})
+
+	// the histograms are cleared on every collection
+	rm.Histogram("response", "/foo", "time").Clear()
+	rm.Histogram("response", "/foo", "time").Update(int64(time.Microsecond))
+	s.update(now.Add(2 * time.Minute))
+	assertSLOGauges(t, service, "router", -3, map[string]float64{"5m": 4})
+}
+
+func TestSLOs_proxyAvailability(t *testing.T) {
+	registry := metrics.NewPrefixedRegistry("krakend.")
+	rm := NewRouterMetrics(&registry)
+	pm := NewProxyMetrics(&registry)
+	NewProxyMiddleware("backend", "/foo", pm)
+	service := metrics.NewPrefixedChildRegistry(registry, "service.")
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := newSLOs([]SLOConfig{{Name: "foo", Layer: "backend", Target: "/foo", Objective: 0.5, Period: time.Hour}}, rm, pm, service, now)
+
+	pm.Counter("requests.layer.backend.name./foo.complete.true.error.false").Inc(6)
+	pm.Counter("requests.layer.backend.name./foo.complete.false.error.false").Inc(2)
+	pm.Counter("requests.layer.backend.name./foo.complete.false.error.true").Inc(2)
+	s.update(now.Add(time.Minute))
+	assertSLOGauges(t, service, "foo", 0.6, map[string]float64{"5m": 0.4, "3d": 0.4})
+}
+
+func TestSLOs_retention(t *testing.T) {
+	registry := metrics.NewRegistry()
+	rm := NewRouterMetrics(&registry)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := newSLOs([]SLOConfig{{Name: "foo", Layer: RouterLayer, Target: "/foo", Objective: 0.99, Period: time.Hour}}, rm, NewProxyMetrics(&registry), registry, now)
+
+	rm.Counter("response", "/foo", "status", "500", "count").Inc(10)
+	s.update(now.Add(time.Hour))
+	assertSLOGauges(t, registry, "foo", -99, map[string]float64{"5m": 100, "1h": 100, "6h": 100, "3d": 100})
+
+	for i := 2; i <= 80; i++ {
+		rm.Counter("response", "/foo", "status", "200", "count").Inc(10)
+		s.update(now.Add(time.Duration(i) * time.Hour))
+	}
+	// the errors of the first hour left every window
+	assertSLOGauges(t, registry, "foo", 1, map[string]float64{"5m": 0, "1h": 0, "6h": 0, "3d": 0})
+
+	// the memory does not grow with the collections
+	o := s.slos[0]
+	for _, w := range append(o.windows, o.period) {
+		if len(w.buckets) != sloBucketsPerWindow {
+			t.Errorf("unexpected number of buckets: %d", len(w.buckets))
+		}
+	}
+
+	var nilSLOs *SLOs
+	nilSLOs.update(now)
+}
+
+func TestSLOWindow(t *testing.T) {
+	w := newSLOWindow(time.Hour)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	w.add(now, 1, 2)
+	w.add(now.Add(30*time.Second), 1, 2)
+	w.add(now.Add(30*time.Minute), 10, 20)
+	if good, total := w.sum(now.Add(59 * time.Minute)); good != 12 || total != 24 {
+		t.Errorf("unexpected sum: %d/%d", good, total)
+	}
+	// the bucket of the first minute expires, and its slot in the ring is reused
+	w.add(now.Add(time.Hour), 100, 200)
+	if good, total := w.sum(now.Add(time.Hour)); good != 110 || total != 220 {
+		t.Errorf("unexpected sum: %d/%d", good, total)
+	}
+	if good, total := w.sum(now.Add(2 * time.Hour)); good != 0 || total != 0 {
+		t.Errorf("unexpected sum: %d/%d", good, total)
+	}
+}
+
+func assertSLOGauges(t *testing.T, r metrics.Registry, name string, budget float64, burnRates map[string]float64) {
+	t.Helper()
+	if v := r.Get("slo." + name + ".error_budget.remaining").(metrics.GaugeFloat64).Value(); math.Abs(v-budget) > 1e-9 {
+		t.Errorf("unexpected error budget of %s: %f", name, v)
+	}
+	for w, want := range burnRates {
+		if v := r.Get("slo." + name + ".burn_rate." + w).(metrics.GaugeFloat64).Value(); math.Abs(v-want) > 1e-9 {
+			t.Errorf("unexpected %s burn rate of %s: %f", w, name, v)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package metrics
+
+import (
+	"fmt"
+	"net/textproto"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/luraproject/lura/v2/logging"
+)
+
+const (
+	// RouterLayer is the layer of the thresholds applied to the router handlers
+	RouterLayer = "router"
+	// defaultMaxExemplars is the number of exemplars kept per histogram when the config does not define it
+	defaultMaxExemplars = 10
+	// defaultRequestIDHeader is the header identifying the requests when the config does not define it
+	defaultRequestIDHeader = "X-Request-Id"
+)
+
+// defaultLatencyBuckets are the upper bounds of the buckets of the duration histograms when the config
+// does not define them
+var defaultLatencyBuckets = []time.Duration{
+	5 * time.Millisecond, 10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond, time.Second,
+	2500 * time.Millisecond, 5 * time.Second, 10 * time.Second,
+}
+
+// SlowRequestsConfig is the config of the slow requests log
+type SlowRequestsConfig struct {
+	// Thresholds are the durations above which a request is slow, by layer. The router handlers use
+	// the RouterLayer and the proxy middlewares the layer they are created with (pipe, backend...)
+	Thresholds map[string]time.Duration
+	// RequestIDHeader is the header logged to identify the slow requests
+	RequestIDHeader string
+	// MaxExemplars is the number of slow requests kept per histogram
+	MaxExemplars int
+	// Buckets are the sorted upper bounds of the buckets counted by the histograms of the durations of
+	// the endpoints and the proxy middlewares, so the OpenMetrics format can expose them as histograms
+	// carrying the exemplars
+	Buckets []time.Duration
+}
+
+func parseSlowRequestsConfig(data map[string]interface{}) *SlowRequestsConfig {
+	tmp, ok := data["slow_requests"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	cfg := &SlowRequestsConfig{
+		Thresholds:      map[string]time.Duration{},
+		RequestIDHeader: defaultRequestIDHeader,
+		MaxExemplars:    defaultMaxExemplars,
+		Buckets:         defaultLatencyBuckets,
+	}
+	if thresholds, ok := tmp["thresholds"].(map[string]interface{}); ok {
+		for layer, v := range thresholds {
+			s, ok := v.(string)
+			if !ok {
+				continue
+			}
+			if d, err := time.ParseDuration(s); err == nil && d > 0 {
+				cfg.Thresholds[layer] = d
+			}
+		}
+	}
+	if len(cfg.Thresholds) == 0 {
+		return nil
+	}
+	if v, ok := tmp["request_id_header"].(string); ok && v != "" {
+		cfg.RequestIDHeader = textproto.CanonicalMIMEHeaderKey(v)
+	}
+	if v, ok := tmp["exemplars"].(float64); ok && v > 0 {
+		cfg.MaxExemplars = int(v)
+	}
+	if v, ok := tmp["buckets"].([]interface{}); ok {
+		if buckets := parseBuckets(v); len(buckets) > 0 {
+			cfg.Buckets = buckets
+		}
+	}
+	return cfg
+}
+
+// parseBuckets returns the sorted and unique positive durations of the list, ignoring the rest
+func parseBuckets(values []interface{}) []time.Duration {
+	res := []time.Duration{}
+	for _, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+		if d, err := time.ParseDuration(s); err == nil && d > 0 {
+			res = append(res, d)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return slices.Compact(res)
+}
+
+// SlowRequest describes a request exceeding the threshold of its layer
+type SlowRequest struct {
+	Layer string
+	// Name is the endpoint or the backend of the instrumented layer
+	Name      string
+	Path      string
+	Duration  time.Duration
+	Status    int
+	RequestID string
+	// Time is the end of the request, measured with the clock of the collector. The current time is
+	// used if it is zero
+	Time time.Time
+}
+
+// Exemplar is a slow request attached to the histogram recording its duration
+type Exemplar struct {
+	RequestID string `json:"request_id,omitempty"`
+	Value     int64  `json:"value"`
+	Time      int64  `json:"time"`
+}
+
+// SlowRequests logs the requests exceeding the threshold of their layer and keeps the last ones as
+// exemplars of the histograms recording their duration. It is safe for concurrent use
+type SlowRequests struct {
+	cfg    SlowRequestsConfig
+	logger logging.Logger
+	prefix string
+	bounds []int64
+
+	mu        sync.RWMutex
+	exemplars map[string][]Exemplar
+}
+
+// NewSlowRequests creates a slow requests log. The prefix is added to the histogram names to match
+// the ones of the registry
+func NewSlowRequests(cfg *SlowRequestsConfig, prefix string, l logging.Logger) *SlowRequests {
+	bounds := make([]int64, len(cfg.Buckets))
+	for i, d := range cfg.Buckets {
+		bounds[i] = int64(d)
+	}
+	return &SlowRequests{cfg: *cfg, logger: l, prefix: prefix, bounds: bounds, exemplars: map[string][]Exemplar{}}
+}
+
+// RequestIDHeader returns the header identifying the requests. It is empty on a nil log
+func (s *SlowRequests) RequestIDHeader() string {
+	if s == nil {
+		return ""
+	}
+	return s.cfg.RequestIDHeader
+}
+
+// Observe logs the request and keeps it as an exemplar of the histogram if it exceeds the threshold
+// of its layer. It does nothing on a nil log
+func (s *SlowRequests) Observe(histogram string, r SlowRequest) {
+	if s == nil {
+		return
+	}
+	threshold, ok := s.cfg.Thresholds[r.Layer]
+	if !ok || r.Duration < threshold {
+		return
+	}
+	s.logger.Warning(fmt.Sprintf(
+		"[SERVICE: Stats] Slow request: layer=%s name=%s path=%s duration=%s status=%d request_id=%s",
+		r.Layer, r.Name, r.Path, r.Duration, r.Status, r.RequestID,
+	))
+
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	e := Exemplar{RequestID: r.RequestID, Value: int64(r.Duration), Time: r.Time.UnixNano()}
+	name := s.prefix + histogram
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	exemplars := append(s.exemplars[name], e)
+	if len(exemplars) > s.cfg.MaxExemplars {
+		exemplars = exemplars[len(exemplars)-s.cfg.MaxExemplars:]
+	}
+	s.exemplars[name] = exemplars
+}
+
+// Exemplars returns a copy of the last slow requests of every histogram, sorted by time. It returns
+// nil on a nil log
+func (s *SlowRequests) Exemplars() map[string][]Exemplar {
+	if s == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	res := make(map[string][]Exemplar, len(s.exemplars))
+	for k, v := range s.exemplars {
+		res[k] = append([]Exemplar{}, v...)
+		sort.Slice(res[k], func(i, j int) bool { return res[k][i].Time < res[k][j].Time })
+	}
+	return res
+}
+
+// EnableSlowRequests makes the instrumented handlers and middlewares report their slow requests. The
+// duration histograms registered afterwards count the buckets of the slow requests config
+func (rm *ProxyMetrics) EnableSlowRequests(s *SlowRequests) {
+	rm.slow = s
+}
+
+// bucketBounds returns the upper bounds (ns) of the buckets of the duration histograms. It returns nil
+// on a nil log
+func (s *SlowRequests) bucketBounds() []int64 {
+	if s == nil {
+		return nil
+	}
+	return s.bounds
+}
+
+// SlowRequests returns the slow requests log. It is nil if it is not enabled
+func (rm *ProxyMetrics) SlowRequests() *SlowRequests {
+	return rm.slow
+}
+
+// Exemplars returns the last slow requests of every histogram, indexed by metric name
+func (m *Metrics) Exemplars() map[string][]Exemplar {
+	if m.Proxy == nil {
+		return nil
+	}
+	return m.Proxy.SlowRequests().Exemplars()
+}
@@ -0,0 +1,183 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/luraproject/lura/v2/logging"
+	"github.com/luraproject/lura/v2/proxy"
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestParseSlowRequestsConfig(t *testing.T) {
+	cfg := parseSlowRequestsConfig(map[string]interface{}{
+		"slow_requests": map[string]interface{}{
+			"thresholds":        map[string]interface{}{"router": "1s", "backend": "200ms", "pipe": "nope"},
+			"request_id_header": "x-trace-id",
+			"exemplars":         3.0,
+			"buckets":           []interface{}{"1s", "100ms", "100ms", "-1s", 3.0},
+		},
+	})
+	if cfg == nil {
+		t.Fatal("nil config")
+	}
+	if len(cfg.Thresholds) != 2 || cfg.Thresholds[RouterLayer] != time.Second || cfg.Thresholds["backend"] != 200*time.Millisecond {
+		t.Errorf("unexpected thresholds: %v", cfg.Thresholds)
+	}
+	if cfg.RequestIDHeader != "X-Trace-Id" || cfg.MaxExemplars != 3 {
+		t.Errorf("unexpected config: %+v", cfg)
+	}
+	if len(cfg.Buckets) != 2 || cfg.Buckets[0] != 100*time.Millisecond || cfg.Buckets[1] != time.Second {
+		t.Errorf("unexpected buckets: %v", cfg.Buckets)
+	}
+	cfg = parseSlowRequestsConfig(map[string]interface{}{
+		"slow_requests": map[string]interface{}{"thresholds": map[string]interface{}{"router": "1s"}},
+	})
+	if cfg == nil || len(cfg.Buckets) != len(defaultLatencyBuckets) {
+		t.Errorf("unexpected default buckets: %+v", cfg)
+	}
+	if cfg := parseSlowRequestsConfig(map[string]interface{}{"slow_requests": map[string]interface{}{}}); cfg != nil {
+		t.Errorf("a config without thresholds should be ignored: %+v", cfg)
+	}
+}
+
+func TestSlowRequests_Observe(t *testing.T) {
+	buf := new(bytes.Buffer)
+	l, _ := logging.NewLogger("DEBUG", buf, "")
+	s := NewSlowRequests(&SlowRequestsConfig{
+		Thresholds:      map[string]time.Duration{RouterLayer: 10 * time.Millisecond},
+		RequestIDHeader: "X-Request-Id",
+		MaxExemplars:    2,
+	}, "krakend.", l)
+
+	s.Observe("a", SlowRequest{Layer: RouterLayer, Name: "/fast", Duration: time.Millisecond})
+	s.Observe("a", SlowRequest{Layer: "backend", Name: "/other-layer", Duration: time.Second})
+	for i, id := range []string{"1", "2", "3"} {
+		s.Observe("a", SlowRequest{Layer: RouterLayer, Name: "/slow", Path: "/slow", Duration: time.Duration(i+1) * time.Second, Status: 200, RequestID: id})
+	}
+
+	if strings.Contains(buf.String(), "/fast") || strings.Contains(buf.String(), "/other-layer") {
+		t.Errorf("unexpected log: %s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "Slow request: layer=router name=/slow path=/slow duration=3s status=200 request_id=3") {
+		t.Errorf("slow request not logged: %s", buf.String())
+	}
+	exemplars := s.Exemplars()["krakend.a"]
+	if len(exemplars) != 2 || exemplars[0].RequestID != "2" || exemplars[1].Value != int64(3*time.Second) {
+		t.Errorf("unexpected exemplars: %+v", exemplars)
+	}
+
+	var disabled *SlowRequests
+	disabled.Observe("a", SlowRequest{Layer: RouterLayer, Duration: time.Hour})
+	if disabled.Exemplars() != nil || disabled.RequestIDHeader() != "" {
+		t.Error("a nil log should be empty")
+	}
+}
+
+func TestNewProxyMiddleware_slowRequests(t *testing.T) {
+	buf := new(bytes.Buffer)
+	l, _ := logging.NewLogger("DEBUG", buf, "")
+	r := metrics.NewPrefixedRegistry("krakend.")
+	m := &Metrics{Registry: &r, Proxy: NewProxyMetrics(&r), Router: NewRouterMetrics(&r)}
+	slow := NewSlowRequests(&SlowRequestsConfig{
+		Thresholds:      map[string]time.Duration{"backend": 5 * time.Millisecond, RouterLayer: 5 * time.Millisecond},
+		RequestIDHeader: "X-Request-Id",
+		MaxExemplars:    5,
+		Buckets:         []time.Duration{5 * time.Millisecond, time.Second},
+	}, "krakend.", l)
+	m.Proxy.EnableSlowRequests(slow)
+	m.Router.EnableSlowRequests(slow)
+
+	p := NewProxyMiddleware("backend", "/users/{id}", m.Proxy)(func(_ context.Context, _ *proxy.Request) (*proxy.Response, error) {
+		time.Sleep(10 * time.Millisecond)
+		return &proxy.Response{IsComplete: true, Metadata: proxy.Metadata{StatusCode: 200}}, nil
+	})
+	p(context.Background(), &proxy.Request{Path: "/users/42", Headers: map[string][]string{"X-Request-Id": {"abc"}}})
+	time.Sleep(10 * time.Millisecond)
+
+	req := httptest.NewRequest(http.MethodGet, "/foo", http.NoBody)
+	req.Header.Set("X-Request-Id", "def")
+	m.Router.SlowResponse("/foo", req, 500, 10*time.Millisecond)
+	m.Router.SlowResponse("/foo", req, 200, time.Millisecond)
+
+	exemplars := m.Exemplars()
+	if e := exemplars["krakend.proxy.latency.layer.backend.name./users/{id}.complete.true.error.false"]; len(e) != 1 || e[0].RequestID != "abc" {
+		t.Errorf("unexpected backend exemplars: %+v", exemplars)
+	}
+	if e := exemplars["krakend.router.response./foo.time"]; len(e) != 1 || e[0].RequestID != "def" {
+		t.Errorf("unexpected router exemplars: %+v", exemplars)
+	}
+	// the duration histograms count the buckets, so the exemplars are exposed in the OpenMetrics format
+	out := new(bytes.Buffer)
+	if err := WriteOpenMetrics(out, r, Filter{Prefix: "krakend.proxy.latency."}, exemplars); err != nil {
+		t.Fatal(err)
+	}
+	name := "krakend_proxy_latency_layer_backend_name__users__id__complete_true_error_false"
+	for _, line := range []string{
+		"# TYPE " + name + " histogram\n",
+		name + `_bucket{le="5000000"} 0` + "\n",
+		name + `_bucket{le="1000000000"} 1 # {request_id="abc"} `,
+	} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("%q not found: %s", line, out.String())
+		}
+	}
+
+	for _, line := range []string{
+		"layer=backend name=/users/{id} path=/users/42",
+		"status=200 request_id=abc",
+		"layer=router name=/foo path=/foo duration=10ms status=500 request_id=def",
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("%q not logged: %s", line, buf.String())
+		}
+	}
+}
+
+func TestSlowRequests_clock(t *testing.T) {
+	l, _ := logging.NewLogger("DEBUG", new(bytes.Buffer), "")
+	r := metrics.NewPrefixedRegistry("krakend.")
+	m := &Metrics{Registry: &r, Proxy: NewProxyMetrics(&r), Router: NewRouterMetrics(&r)}
+	slow := NewSlowRequests(&SlowRequestsConfig{
+		Thresholds:   map[string]time.Duration{"backend": time.Second, RouterLayer: time.Second},
+		MaxExemplars: 5,
+	}, "krakend.", l)
+	m.Proxy.EnableSlowRequests(slow)
+	m.Router.EnableSlowRequests(slow)
+	var mu sync.Mutex
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	m.Proxy.SetClock(clock)
+	m.Router.SetClock(clock)
+
+	p := NewProxyMiddleware("backend", "/foo", m.Proxy)(func(_ context.Context, _ *proxy.Request) (*proxy.Response, error) {
+		mu.Lock()
+		now = now.Add(2 * time.Second)
+		mu.Unlock()
+		return &proxy.Response{IsComplete: true}, nil
+	})
+	p(context.Background(), &proxy.Request{})
+	m.Router.SlowResponse("/foo", httptest.NewRequest(http.MethodGet, "/foo", http.NoBody), 200, 2*time.Second)
+
+	name := "krakend.proxy.latency.layer.backend.name./foo.complete.true.error.false"
+	for deadline := time.Now().Add(time.Second); len(m.Exemplars()[name]) == 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	want := now.UnixNano()
+	exemplars := m.Exemplars()
+	for _, k := range []string{name, "krakend.router.response./foo.time"} {
+		if e := exemplars[k]; len(e) != 1 || e[0].Time != want || e[0].Value != int64(2*time.Second) {
+			t.Errorf("unexpected exemplars of %s: %+v", k, e)
+		}
+	}
+}
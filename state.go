@@ -0,0 +1,187 @@
+package metrics
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/luraproject/lura/v2/logging"
+	"github.com/rcrowley/go-metrics"
+)
+
+const (
+	// stateVersion is the version of the format of the state file
+	stateVersion = 1
+	// defaultCheckpointInterval is the time between checkpoints when the config does not define it
+	defaultCheckpointInterval = time.Minute
+	// persistedPrefix is the prefix of the counters stored in the state file
+	persistedPrefix = "krakend."
+	// servicePrefix is the prefix of the counters about the collector itself, that are not persisted
+	servicePrefix = "krakend.service."
+	// consumerPrefix is the prefix of the per consumer counters, that are not persisted since their
+	// slots are assigned and evicted by the consumers tracker
+	consumerPrefix = "krakend.router.consumer."
+)
+
+// StateConfig is the config of the state file persisting the counters across restarts
+type StateConfig struct {
+	Path               string
+	CheckpointInterval time.Duration
+}
+
+func parseStateConfig(data map[string]interface{}) *StateConfig {
+	tmp, ok := data["state"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	path, ok := tmp["path"].(string)
+	if !ok || path == "" {
+		return nil
+	}
+	cfg := &StateConfig{Path: path, CheckpointInterval: defaultCheckpointInterval}
+	if v, ok := tmp["checkpoint_interval"].(string); ok {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			cfg.CheckpointInterval = d
+		}
+	}
+	return cfg
+}
+
+// state is the content of the state file
+type state struct {
+	Version  int              `json:"version"`
+	Time     int64            `json:"time"`
+	Checksum string           `json:"checksum"`
+	Counters map[string]int64 `json:"counters"`
+}
+
+// ErrInvalidState is returned when the state file is corrupted or has an unsupported version
+var ErrInvalidState = errors.New("invalid state file")
+
+type stateStore struct {
+	cfg    StateConfig
+	logger logging.Logger
+}
+
+func newStateStore(cfg *StateConfig, l logging.Logger) *stateStore {
+	if cfg == nil {
+		return nil
+	}
+	return &stateStore{cfg: *cfg, logger: l}
+}
+
+// restore adds the counters stored in the state file to the ones in the registry. A missing file is
+// not an error. An invalid one is moved aside, so the next checkpoint does not overwrite it
+func (s *stateStore) restore(r metrics.Registry) error {
+	counters, err := readState(s.cfg.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if errors.Is(err, ErrInvalidState) {
+		if renameErr := os.Rename(s.cfg.Path, s.cfg.Path+".invalid"); renameErr != nil {
+			return renameErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	for k, v := range counters {
+		// the files written by previous versions may contain counters not persisted anymore
+		if isPersisted(k) {
+			metrics.GetOrRegisterCounter(strings.TrimPrefix(k, persistedPrefix), r).Inc(v)
+		}
+	}
+	return nil
+}
+
+// checkpoint stores the current value of the persisted counters in the state file
+func (s *stateStore) checkpoint(r metrics.Registry) {
+	if s == nil {
+		return
+	}
+	counters := map[string]int64{}
+	r.Each(func(k string, v interface{}) {
+		if c, ok := v.(metrics.Counter); ok && isPersisted(k) {
+			counters[k] = c.Count()
+		}
+	})
+	if err := writeState(s.cfg.Path, counters); err != nil {
+		s.logger.Warning("[SERVICE: Stats] Unable to checkpoint the counters:", err.Error())
+	}
+}
+
+// isPersisted reports whether the counter is stored in the state file
+func isPersisted(name string) bool {
+	return strings.HasPrefix(name, persistedPrefix) && !strings.HasPrefix(name, servicePrefix) &&
+		!strings.HasPrefix(name, consumerPrefix)
+}
+
+func readState(path string) (map[string]int64, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var st state
+	if err := json.Unmarshal(b, &st); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidState, err)
+	}
+	if st.Version != stateVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidState, st.Version)
+	}
+	sum, err := countersChecksum(st.Counters)
+	if err != nil {
+		return nil, err
+	}
+	if sum != st.Checksum {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrInvalidState)
+	}
+	return st.Counters, nil
+}
+
+// writeState replaces the state file atomically, so a crash while writing it does not corrupt it
+func writeState(path string, counters map[string]int64) error {
+	sum, err := countersChecksum(counters)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(state{Version: stateVersion, Time: time.Now().UnixNano(), Checksum: sum, Counters: counters})
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// countersChecksum returns the sha256 of the JSON encoded counters. The keys of the encoded maps are
+// sorted, so the checksum does not depend on the iteration order
+func countersChecksum(counters map[string]int64) (string, error) {
+	b, err := json.Marshal(counters)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
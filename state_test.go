@@ -0,0 +1,189 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/logging"
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestNew_state(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	extra := config.ExtraConfig{Namespace: map[string]interface{}{
+		"collection_time": "1h",
+		"state":           map[string]interface{}{"path": path, "checkpoint_interval": "10ms"},
+	}}
+	l, _ := logging.NewLogger("DEBUG", new(bytes.Buffer), "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m := New(ctx, extra, l)
+	if m.Config.State == nil || m.Config.State.CheckpointInterval != 10*time.Millisecond {
+		t.Errorf("unexpected state config: %+v", m.Config.State)
+	}
+	m.Router.Connection(nil)
+	m.Router.Aggregate()
+	m.Proxy.Counter("requests", "layer", "backend").Inc(40)
+	metrics.GetOrRegisterCounter("service.sinks.foo.exports", *m.Registry).Inc(1)
+	m.Router.Counter("consumer", "alice", "requests").Inc(1)
+
+	time.Sleep(50 * time.Millisecond)
+	counters, err := readState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counters["krakend.proxy.requests.layer.backend"] != 40 {
+		t.Errorf("unexpected checkpoint: %v", counters)
+	}
+
+	m.Proxy.Counter("requests", "layer", "backend").Inc(2)
+	cancel()
+	<-m.Done()
+
+	m = New(context.Background(), extra, l)
+	snapshot := m.TakeSnapshot()
+	if v := snapshot.Counters["krakend.proxy.requests.layer.backend"]; v != 42 {
+		t.Errorf("unexpected restored counter: %d", v)
+	}
+	if v := snapshot.Counters["krakend.router.connected-total"]; v != 1 {
+		t.Errorf("unexpected restored counter: %d", v)
+	}
+	if _, ok := snapshot.Counters["krakend.service.sinks.foo.exports"]; ok {
+		t.Error("the service counters should not be persisted")
+	}
+	// the consumers tracker does not know the restored consumers, so they would escape its limits
+	if _, ok := snapshot.Counters["krakend.router.consumer.alice.requests"]; ok {
+		t.Error("the consumer counters should not be persisted")
+	}
+}
+
+func TestStateStore_restoreConsumers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	l, _ := logging.NewLogger("DEBUG", new(bytes.Buffer), "")
+	// a state file written before the consumer counters were excluded
+	if err := writeState(path, map[string]int64{"krakend.foo": 1, "krakend.router.consumer.alice.requests": 3}); err != nil {
+		t.Fatal(err)
+	}
+	r := metrics.NewPrefixedRegistry("krakend.")
+	if err := newStateStore(&StateConfig{Path: path}, l).restore(r); err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	r.Each(func(k string, _ interface{}) { names = append(names, k) })
+	if len(names) != 1 || names[0] != "krakend.foo" {
+		t.Errorf("unexpected restored counters: %v", names)
+	}
+}
+
+// blockingSink blocks its Close until release is closed
+type blockingSink struct {
+	release chan struct{}
+}
+
+func (*blockingSink) Start(_ context.Context) error           { return nil }
+func (*blockingSink) Export(_ context.Context, _ Stats) error { return nil }
+func (*blockingSink) Flush(_ context.Context) error           { return nil }
+func (s *blockingSink) Close() error {
+	<-s.release
+	return nil
+}
+
+func TestNew_stateShutdown(t *testing.T) {
+	sink := &blockingSink{release: make(chan struct{})}
+	RegisterSink("test-blocking", func(_ map[string]interface{}, _ logging.Logger) (Sink, error) {
+		return sink, nil
+	})
+	path := filepath.Join(t.TempDir(), "state.json")
+	extra := config.ExtraConfig{Namespace: map[string]interface{}{
+		"collection_time": "1h",
+		"state":           map[string]interface{}{"path": path, "checkpoint_interval": "1h"},
+		"sinks":           map[string]interface{}{"test-blocking": map[string]interface{}{}},
+	}}
+	l, _ := logging.NewLogger("DEBUG", new(bytes.Buffer), "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m := New(ctx, extra, l)
+	m.Proxy.Counter("requests", "layer", "backend").Inc(3)
+	cancel()
+
+	// the checkpoint must not wait for the sinks
+	deadline := time.Now().Add(time.Second)
+	for {
+		if counters, err := readState(path); err == nil {
+			if counters["krakend.proxy.requests.layer.backend"] != 3 {
+				t.Errorf("unexpected checkpoint: %v", counters)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the state was not checkpointed while the sinks were closing")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	select {
+	case <-m.Done():
+		t.Fatal("the collector should not be done while a sink is closing")
+	default:
+	}
+	close(sink.release)
+	select {
+	case <-m.Done():
+	case <-time.After(time.Second):
+		t.Fatal("the collector did not stop")
+	}
+}
+
+func TestStateStore_restoreInvalid(t *testing.T) {
+	dir := t.TempDir()
+	buf := new(bytes.Buffer)
+	l, _ := logging.NewLogger("DEBUG", buf, "")
+
+	valid := filepath.Join(dir, "valid.json")
+	if err := writeState(valid, map[string]int64{"krakend.foo": 1}); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(valid)
+
+	for name, content := range map[string]string{
+		"garbage":  "{not json",
+		"version":  strings.Replace(string(b), `"version":1`, `"version":2`, 1),
+		"checksum": strings.Replace(string(b), `"krakend.foo":1`, `"krakend.foo":1000`, 1),
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		r := metrics.NewRegistry()
+		s := newStateStore(&StateConfig{Path: path}, l)
+		if err := s.restore(r); err == nil {
+			t.Errorf("%s: error expected", name)
+		}
+		if r.Get("foo") != nil {
+			t.Errorf("%s: the counters should not be restored", name)
+		}
+		if _, err := os.Stat(path + ".invalid"); err != nil {
+			t.Errorf("%s: the invalid file should be kept: %v", name, err)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s: the invalid file should be moved: %v", name, err)
+		}
+	}
+
+	r := metrics.NewRegistry()
+	if err := newStateStore(&StateConfig{Path: filepath.Join(dir, "missing")}, l).restore(r); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := newStateStore(&StateConfig{Path: valid}, l).restore(r); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if v := r.Get("foo").(metrics.Counter).Count(); v != 1 {
+		t.Errorf("unexpected restored counter: %d", v)
+	}
+}
@@ -5,19 +5,24 @@ import "time"
 // NewStats instantiates a stats struct
 func NewStats() Stats {
 	return Stats{
-		Time:       time.Now().UnixNano(),
-		Counters:   map[string]int64{},
-		Gauges:     map[string]int64{},
-		Histograms: map[string]HistogramData{},
+		Time:        time.Now().UnixNano(),
+		Counters:    map[string]int64{},
+		Gauges:      map[string]int64{},
+		FloatGauges: map[string]float64{},
+		Histograms:  map[string]HistogramData{},
 	}
 }
 
 // Stats represents a snapshot of the collected metrics
 type Stats struct {
-	Time       int64
-	Counters   map[string]int64
-	Gauges     map[string]int64
-	Histograms map[string]HistogramData
+	Time     int64
+	Counters map[string]int64
+	Gauges   map[string]int64
+	// FloatGauges contains the gauges with decimal values, like the Apdex scores
+	FloatGauges map[string]float64 `json:",omitempty"`
+	Histograms  map[string]HistogramData
+	// Anomalies contains the anomalies detected on the snapshot, if the anomaly detection is enabled
+	Anomalies []AnomalyEvent `json:",omitempty"`
 }
 
 // HistogramData is a snapshot of an actual histogram
@@ -29,3 +34,20 @@ type HistogramData struct {
 	Variance    float64
 	Percentiles []float64
 }
+
+// Delta returns a copy of the snapshot with its counters replaced by their increment since the
+// previous snapshot. Gauges and histograms are not cumulative, so they are kept as they are
+func (s Stats) Delta(previous Stats) Stats {
+	res := Stats{
+		Time:        s.Time,
+		Counters:    make(map[string]int64, len(s.Counters)),
+		Gauges:      s.Gauges,
+		FloatGauges: s.FloatGauges,
+		Histograms:  s.Histograms,
+		Anomalies:   s.Anomalies,
+	}
+	for k, v := range s.Counters {
+		res.Counters[k] = v - previous.Counters[k]
+	}
+	return res
+}
@@ -0,0 +1,62 @@
+package metrics
+
+import "sync"
+
+// subscriberBuffer is the number of snapshots a subscriber can have pending before being dropped
+const subscriberBuffer = 4
+
+type subscribers struct {
+	mu     sync.Mutex
+	chans  map[chan Stats]struct{}
+	closed bool
+}
+
+// Subscribe returns a channel receiving every new snapshot and a function to cancel the subscription.
+// The channel is closed when the subscriber is too slow to keep up with the collection ticks, when the
+// subscription is cancelled and when the context of the metrics collector is done
+func (m *Metrics) Subscribe() (<-chan Stats, func()) {
+	ch := make(chan Stats, subscriberBuffer)
+
+	m.subs.mu.Lock()
+	defer m.subs.mu.Unlock()
+	if m.subs.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	if m.subs.chans == nil {
+		m.subs.chans = map[chan Stats]struct{}{}
+	}
+	m.subs.chans[ch] = struct{}{}
+
+	return ch, func() {
+		m.subs.mu.Lock()
+		defer m.subs.mu.Unlock()
+		if _, ok := m.subs.chans[ch]; ok {
+			delete(m.subs.chans, ch)
+			close(ch)
+		}
+	}
+}
+
+func (m *Metrics) publish(s Stats) {
+	m.subs.mu.Lock()
+	defer m.subs.mu.Unlock()
+	for ch := range m.subs.chans {
+		select {
+		case ch <- s:
+		default:
+			delete(m.subs.chans, ch)
+			close(ch)
+		}
+	}
+}
+
+func (m *Metrics) closeSubscribers() {
+	m.subs.mu.Lock()
+	defer m.subs.mu.Unlock()
+	for ch := range m.subs.chans {
+		close(ch)
+	}
+	m.subs.chans = nil
+	m.subs.closed = true
+}
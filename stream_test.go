@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"testing"
+)
+
+func TestMetrics_Subscribe(t *testing.T) {
+	m := Metrics{latestSnapshot: NewStats()}
+
+	fast, cancelFast := m.Subscribe()
+	defer cancelFast()
+	slow, cancelSlow := m.Subscribe()
+	defer cancelSlow()
+
+	for i := 0; i < subscriberBuffer+1; i++ {
+		s := NewStats()
+		s.Counters["requests"] = int64(i)
+		m.storeSnapshot(s)
+		if v := <-fast; v.Counters["requests"] != int64(i) {
+			t.Errorf("unexpected snapshot: %v", v)
+		}
+	}
+
+	received := 0
+	for range slow {
+		received++
+	}
+	if received != subscriberBuffer {
+		t.Errorf("the slow subscriber should be dropped after %d snapshots. received: %d", subscriberBuffer, received)
+	}
+
+	m.closeSubscribers()
+	if _, ok := <-fast; ok {
+		t.Error("the subscription should be closed")
+	}
+	late, _ := m.Subscribe()
+	if _, ok := <-late; ok {
+		t.Error("subscriptions after the shutdown should be closed")
+	}
+}
+
+func TestStats_Delta(t *testing.T) {
+	previous := NewStats()
+	previous.Counters["a"] = 10
+	current := NewStats()
+	current.Counters["a"] = 15
+	current.Counters["b"] = 3
+	current.Gauges["c"] = 42
+
+	delta := current.Delta(previous)
+	if delta.Counters["a"] != 5 || delta.Counters["b"] != 3 {
+		t.Errorf("unexpected counters: %v", delta.Counters)
+	}
+	if delta.Gauges["c"] != 42 {
+		t.Errorf("unexpected gauges: %v", delta.Gauges)
+	}
+	if current.Counters["a"] != 15 {
+		t.Error("the original snapshot should not be modified")
+	}
+}
@@ -0,0 +1,285 @@
+package metrics
+
+import (
+	"container/heap"
+	"hash/maphash"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultTopK is the number of heavy hitters reported when the config does not define it
+	defaultTopK = 10
+	// sketchDepth and sketchWidth are the dimensions of the count-min sketch. With these values, the
+	// estimations exceed the real counts by less than 0.14% of the total with a 98% probability
+	sketchDepth = 4
+	sketchWidth = 2048
+)
+
+// TopConfig is the config of the heavy hitters tracker
+type TopConfig struct {
+	// K is the number of heavy hitters reported
+	K int
+	// TrustedProxies is the list of networks whose X-Forwarded-For header is trusted to find the
+	// client IP
+	TrustedProxies []string
+}
+
+func parseTopConfig(data map[string]interface{}) *TopConfig {
+	tmp, ok := data["top"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	cfg := &TopConfig{K: defaultTopK}
+	if v, ok := tmp["k"].(float64); ok && v > 0 {
+		cfg.K = int(v)
+	}
+	if cidrs, ok := tmp["trusted_proxies"].([]interface{}); ok {
+		for _, cidr := range cidrs {
+			if c, ok := cidr.(string); ok {
+				cfg.TrustedProxies = append(cfg.TrustedProxies, c)
+			}
+		}
+	}
+	return cfg
+}
+
+// TopEntry is a heavy hitter and the estimation of its count
+type TopEntry struct {
+	Key   string `json:"key"`
+	Count int64  `json:"count"`
+}
+
+// TopReport contains the heavy hitters of a collection interval
+type TopReport struct {
+	Start int64      `json:"start"`
+	End   int64      `json:"end"`
+	IPs   []TopEntry `json:"ips"`
+	Paths []TopEntry `json:"paths"`
+}
+
+// HeavyHitters tracks the client IPs and the paths with more requests during the collection interval
+type HeavyHitters struct {
+	ips     *TopK
+	paths   *TopK
+	trusted []*net.IPNet
+
+	mu    sync.RWMutex
+	start time.Time
+	last  *TopReport
+}
+
+// NewHeavyHitters creates a heavy hitters tracker. It returns an error if a trusted proxy is not a
+// valid CIDR
+func NewHeavyHitters(cfg *TopConfig) (*HeavyHitters, error) {
+	h := &HeavyHitters{ips: NewTopK(cfg.K), paths: NewTopK(cfg.K), start: time.Now()}
+	for _, cidr := range cfg.TrustedProxies {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		h.trusted = append(h.trusted, network)
+	}
+	return h, nil
+}
+
+// Record adds the request to the counts of its client IP and its raw path. It does nothing on a nil
+// tracker
+func (h *HeavyHitters) Record(r *http.Request) {
+	if h == nil {
+		return
+	}
+	h.ips.Add(h.ClientIP(r))
+	h.paths.Add(r.URL.Path)
+}
+
+// ClientIP returns the IP of the client. If the request comes from a trusted proxy, the X-Forwarded-For
+// header is walked from right to left and the first address not belonging to a trusted proxy is used
+func (h *HeavyHitters) ClientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !h.isTrusted(ip) {
+		return ip
+	}
+	hops := []string{}
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(v, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip = hops[i]
+		if !h.isTrusted(ip) {
+			break
+		}
+	}
+	return ip
+}
+
+func (h *HeavyHitters) isTrusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range h.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Rotate closes the current interval, keeping its heavy hitters as the last report, and starts a new one
+func (h *HeavyHitters) Rotate() {
+	if h == nil {
+		return
+	}
+	now := time.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.last = &TopReport{
+		Start: h.start.UnixNano(),
+		End:   now.UnixNano(),
+		IPs:   h.ips.Reset(),
+		Paths: h.paths.Reset(),
+	}
+	h.start = now
+}
+
+// Report returns the heavy hitters of the last complete interval. Before the end of the first
+// interval, it returns the ones of the current interval
+func (h *HeavyHitters) Report() TopReport {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.last != nil {
+		return *h.last
+	}
+	return TopReport{Start: h.start.UnixNano(), End: time.Now().UnixNano(), IPs: h.ips.Top(), Paths: h.paths.Top()}
+}
+
+// TopK estimates the k most frequent keys of a stream using a count-min sketch for the counts and a
+// min-heap to keep the heavy hitters. It is safe for concurrent use
+type TopK struct {
+	k      int
+	mu     sync.Mutex
+	seeds  [sketchDepth]maphash.Seed
+	sketch [sketchDepth][sketchWidth]int64
+	heap   topHeap
+	index  map[string]int
+}
+
+// NewTopK creates a TopK tracking the k most frequent keys
+func NewTopK(k int) *TopK {
+	t := &TopK{k: k, index: map[string]int{}}
+	for i := range t.seeds {
+		t.seeds[i] = maphash.MakeSeed()
+	}
+	t.heap.index = t.index
+	return t
+}
+
+// Add counts an occurrence of the key
+func (t *TopK) Add(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var estimation int64
+	for i := range t.sketch {
+		cell := &t.sketch[i][maphash.String(t.seeds[i], key)%sketchWidth]
+		*cell++
+		if i == 0 || *cell < estimation {
+			estimation = *cell
+		}
+	}
+
+	if i, ok := t.index[key]; ok {
+		t.heap.entries[i].Count = estimation
+		heap.Fix(&t.heap, i)
+		return
+	}
+	if len(t.heap.entries) < t.k {
+		heap.Push(&t.heap, TopEntry{Key: key, Count: estimation})
+		return
+	}
+	if estimation > t.heap.entries[0].Count {
+		delete(t.index, t.heap.entries[0].Key)
+		t.heap.entries[0] = TopEntry{Key: key, Count: estimation}
+		t.index[key] = 0
+		heap.Fix(&t.heap, 0)
+	}
+}
+
+// Top returns the heavy hitters, sorted by count
+func (t *TopK) Top() []TopEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.top()
+}
+
+// Reset returns the heavy hitters, sorted by count, and clears the counts
+func (t *TopK) Reset() []TopEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	res := t.top()
+	t.sketch = [sketchDepth][sketchWidth]int64{}
+	t.heap.entries = t.heap.entries[:0]
+	clear(t.index)
+	return res
+}
+
+func (t *TopK) top() []TopEntry {
+	res := make([]TopEntry, len(t.heap.entries))
+	copy(res, t.heap.entries)
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Count == res[j].Count {
+			return res[i].Key < res[j].Key
+		}
+		return res[i].Count > res[j].Count
+	})
+	return res
+}
+
+// topHeap is a min-heap of entries keeping the position of every key in the index
+type topHeap struct {
+	entries []TopEntry
+	index   map[string]int
+}
+
+func (h topHeap) Len() int           { return len(h.entries) }
+func (h topHeap) Less(i, j int) bool { return h.entries[i].Count < h.entries[j].Count }
+func (h topHeap) Swap(i, j int) {
+	h.entries[i], h.entries[j] = h.entries[j], h.entries[i]
+	h.index[h.entries[i].Key] = i
+	h.index[h.entries[j].Key] = j
+}
+
+func (h *topHeap) Push(x interface{}) {
+	e := x.(TopEntry)
+	h.index[e.Key] = len(h.entries)
+	h.entries = append(h.entries, e)
+}
+
+func (h *topHeap) Pop() interface{} {
+	e := h.entries[len(h.entries)-1]
+	h.entries = h.entries[:len(h.entries)-1]
+	delete(h.index, e.Key)
+	return e
+}
+
+// EnableHeavyHitters makes the instrumented handlers feed the heavy hitters tracker
+func (rm *RouterMetrics) EnableHeavyHitters(h *HeavyHitters) {
+	rm.heavyHitters = h
+}
+
+// HeavyHitters returns the heavy hitters tracker of the router. It is nil if it is not enabled
+func (rm *RouterMetrics) HeavyHitters() *HeavyHitters {
+	return rm.heavyHitters
+}
@@ -0,0 +1,119 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTopK(t *testing.T) {
+	top := NewTopK(3)
+	// a long tail of keys seen once and three heavy hitters
+	for i := 0; i < 5000; i++ {
+		top.Add(fmt.Sprintf("tail-%d", i))
+		if i%10 == 0 {
+			top.Add("first")
+		}
+		if i%20 == 0 {
+			top.Add("second")
+		}
+		if i%50 == 0 {
+			top.Add("third")
+		}
+	}
+
+	res := top.Top()
+	if len(res) != 3 {
+		t.Fatalf("unexpected result: %v", res)
+	}
+	for i, want := range []struct {
+		key   string
+		count int64
+	}{{"first", 500}, {"second", 250}, {"third", 100}} {
+		// the sketch can only overestimate the counts
+		if res[i].Key != want.key || res[i].Count < want.count || res[i].Count > want.count+20 {
+			t.Errorf("unexpected entry #%d: %+v", i, res[i])
+		}
+	}
+
+	if reset := top.Reset(); len(reset) != 3 || reset[0].Key != "first" {
+		t.Errorf("unexpected result: %v", reset)
+	}
+	if res := top.Top(); len(res) != 0 {
+		t.Errorf("unexpected result after the reset: %v", res)
+	}
+	top.Add("new")
+	if res := top.Top(); len(res) != 1 || res[0].Count != 1 {
+		t.Errorf("unexpected result after the reset: %v", res)
+	}
+}
+
+func TestHeavyHitters_ClientIP(t *testing.T) {
+	h, err := NewHeavyHitters(&TopConfig{K: 10, TrustedProxies: []string{"10.0.0.0/8", "192.168.1.1/32"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		remote string
+		xff    []string
+		want   string
+	}{
+		{remote: "1.2.3.4:1234", want: "1.2.3.4"},
+		// the header is ignored when the request does not come from a trusted proxy
+		{remote: "1.2.3.4:1234", xff: []string{"5.6.7.8"}, want: "1.2.3.4"},
+		{remote: "10.0.0.1:1234", xff: []string{"5.6.7.8"}, want: "5.6.7.8"},
+		// the spoofed hops added by the client are skipped
+		{remote: "10.0.0.1:1234", xff: []string{"6.6.6.6, 5.6.7.8", "192.168.1.1"}, want: "5.6.7.8"},
+		{remote: "10.0.0.1:1234", xff: []string{"10.0.0.3, 10.0.0.2"}, want: "10.0.0.3"},
+		{remote: "10.0.0.1:1234", want: "10.0.0.1"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		req.RemoteAddr = tc.remote
+		for _, v := range tc.xff {
+			req.Header.Add("X-Forwarded-For", v)
+		}
+		if ip := h.ClientIP(req); ip != tc.want {
+			t.Errorf("%s %v: want %s, have %s", tc.remote, tc.xff, tc.want, ip)
+		}
+	}
+
+	if _, err := NewHeavyHitters(&TopConfig{K: 1, TrustedProxies: []string{"nope"}}); err == nil {
+		t.Error("error expected")
+	}
+}
+
+func TestHeavyHitters_Rotate(t *testing.T) {
+	h, _ := NewHeavyHitters(&TopConfig{K: 2})
+	record := func(remote, path string) {
+		req := httptest.NewRequest(http.MethodGet, path, http.NoBody)
+		req.RemoteAddr = remote
+		h.Record(req)
+	}
+	record("1.1.1.1:1", "/a")
+	record("1.1.1.1:1", "/b")
+	record("2.2.2.2:1", "/a")
+
+	report := h.Report()
+	if len(report.IPs) != 2 || report.IPs[0] != (TopEntry{Key: "1.1.1.1", Count: 2}) {
+		t.Errorf("unexpected report of the current interval: %+v", report)
+	}
+
+	h.Rotate()
+	record("3.3.3.3:1", "/c")
+	report = h.Report()
+	if len(report.Paths) != 2 || report.Paths[0] != (TopEntry{Key: "/a", Count: 2}) || report.Start >= report.End {
+		t.Errorf("unexpected report of the last interval: %+v", report)
+	}
+
+	h.Rotate()
+	report = h.Report()
+	if len(report.IPs) != 1 || report.IPs[0].Key != "3.3.3.3" {
+		t.Errorf("unexpected report of the last interval: %+v", report)
+	}
+
+	var disabled *HeavyHitters
+	disabled.Record(httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+	disabled.Rotate()
+}